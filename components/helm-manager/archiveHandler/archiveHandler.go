@@ -0,0 +1,101 @@
+package archiveHandler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrUnsafePath       = errors.New("archive entry escapes the destination directory")
+	ErrUnsupportedEntry = errors.New("archive entry type not supported")
+	ErrTooManyEntries   = errors.New("archive contains too many entries")
+	ErrTooLarge         = errors.New("archive uncompressed size exceeds the limit")
+	ErrCompressionRatio = errors.New("archive compression ratio exceeds the limit")
+	ErrDuplicateEntry   = errors.New("archive contains the same path more than once")
+)
+
+// Limits protegge dall'estrazione di archivi malevoli (zip bomb, archivi con milioni di file)
+type Limits struct {
+	// dimensione massima totale dei file estratti, in byte
+	MaxTotalSize int64
+	// numero massimo di file e cartelle nell'archivio
+	MaxEntries int
+	// rapporto massimo tra dimensione estratta e dimensione compressa
+	MaxCompressionRatio int64
+}
+
+// safeJoin restituisce il percorso di name dentro destDir, rifiutando percorsi assoluti
+// o che contengono ".." e porterebbero fuori dalla cartella di destinazione
+func safeJoin(destDir string, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+		}
+	}
+	target := filepath.Join(destDir, name)
+	rel, err := filepath.Rel(destDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return target, nil
+}
+
+// sizeBudget tiene il conto dei byte estratti rispetto al limite totale
+type sizeBudget struct {
+	limits  Limits
+	written int64
+}
+
+// writeFile copia il contenuto di una voce dell'archivio su disco senza superare il limite totale,
+// il file viene chiuso subito e non alla fine dell'estrazione
+func (b *sizeBudget) writeFile(target string, src io.Reader, perm os.FileMode) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return 0, err
+	}
+	fileToCreate, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_EXCL, filePerm(perm))
+	// con O_EXCL un percorso ripetuto nell'archivio non sovrascrive il file già estratto
+	if errors.Is(err, os.ErrExist) {
+		return 0, ErrDuplicateEntry
+	}
+	if err != nil {
+		return 0, err
+	}
+	remaining := b.limits.MaxTotalSize - b.written
+	n, err := io.Copy(fileToCreate, io.LimitReader(src, remaining+1))
+	closeErr := fileToCreate.Close()
+	b.written += n
+	if err != nil {
+		return n, err
+	}
+	if closeErr != nil {
+		return n, closeErr
+	}
+	if n > remaining {
+		return n, ErrTooLarge
+	}
+	return n, nil
+}
+
+// i permessi dell'archivio non vengono copiati così come sono: niente setuid e file sempre leggibili
+func filePerm(perm os.FileMode) os.FileMode {
+	perm = perm.Perm() & 0755
+	return perm | 0644
+}
+
+func checkRatio(uncompressed int64, compressed int64, maxRatio int64) error {
+	if compressed <= 0 {
+		compressed = 1
+	}
+	if maxRatio > 0 && uncompressed/compressed > maxRatio {
+		return ErrCompressionRatio
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package archiveHandler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// limiti usati dai test, piccoli per non scrivere file grandi su disco
+var testLimits = Limits{
+	MaxTotalSize:        1 << 20,
+	MaxEntries:          10,
+	MaxCompressionRatio: 100,
+}
+
+func TestSafeJoin(t *testing.T) {
+	destDir := t.TempDir()
+	tests := []struct {
+		name    string
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{name: "nested file", entry: "app/src/main.py", want: filepath.Join(destDir, "app", "src", "main.py")},
+		{name: "leading dot", entry: "./app/main.py", want: filepath.Join(destDir, "app", "main.py")},
+		{name: "parent directory", entry: "../evil", wantErr: true},
+		{name: "parent directory in the middle", entry: "app/../../evil", wantErr: true},
+		{name: "parent directory that stays inside", entry: "app/../main.py", wantErr: true},
+		{name: "absolute path", entry: "/etc/passwd", wantErr: true},
+		{name: "empty name", entry: "", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := safeJoin(destDir, test.entry)
+			if test.wantErr {
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Fatalf("safeJoin(%q) error = %v, want %v", test.entry, err, ErrUnsafePath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("safeJoin(%q) = %q, want %q", test.entry, got, test.want)
+			}
+		})
+	}
+}
+
+// assertFiles verifica il contenuto dei file estratti in destDir
+func assertFiles(t *testing.T, destDir string, want map[string]string) {
+	t.Helper()
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil {
+			t.Errorf("extracted file %s: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("extracted file %s = %q, want %q", name, data, content)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package archiveHandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrUnknownFormat = errors.New("archive format not recognized, supported formats are zip, tar, tar.gz and tar.zst")
+
+// Extractor estrae un archivio in una cartella rispettando i limiti
+type Extractor interface {
+	Extract(archivePath string, destDir string, limits Limits) error
+}
+
+type zipExtractor struct{}
+
+func (zipExtractor) Extract(archivePath string, destDir string, limits Limits) error {
+	return ExtractZip(archivePath, destDir, limits)
+}
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic      = []byte("ustar")
+)
+
+// offset della stringa "ustar" nell'header di un archivio tar
+const tarMagicOffset = 257
+
+// Detect sceglie l'estrattore in base ai primi byte del file e non all'estensione,
+// che dipende da come l'archivio è stato caricato
+func Detect(archivePath string) (Extractor, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	// un file vuoto o più corto dell'header non è un errore di lettura, viene rifiutato sotto
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, emptyZipMagic):
+		return zipExtractor{}, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		return tarExtractor{decompress: gunzip}, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		return tarExtractor{decompress: unzstd}, nil
+	case len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return tarExtractor{}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Extract riconosce il formato dell'archivio e lo estrae in destDir
+func Extract(archivePath string, destDir string, limits Limits) error {
+	extractor, err := Detect(archivePath)
+	if err != nil {
+		return err
+	}
+	return extractor.Extract(archivePath, destDir, limits)
+}
+
+func gunzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func unzstd(r io.Reader) (io.ReadCloser, error) {
+	// finestra limitata per non allocare troppa memoria con frame costruiti ad arte
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(32<<20))
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
@@ -0,0 +1,83 @@
+package archiveHandler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractDetectsFormat(t *testing.T) {
+	files := map[string]string{"app/main.py": "print('hello')"}
+	tarEntries := []tarEntry{{name: "app/main.py", body: "print('hello')"}}
+	// scrive un file che non è un archivio
+	writeRaw := func(content string) func(t *testing.T) string {
+		return func(t *testing.T) string {
+			archivePath := filepath.Join(t.TempDir(), "upload.zip")
+			err := os.WriteFile(archivePath, []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return archivePath
+		}
+	}
+	tests := []struct {
+		name      string
+		archive   func(t *testing.T) string
+		wantErr   error
+		wantFiles map[string]string
+	}{
+		{
+			name: "zip",
+			archive: func(t *testing.T) string {
+				return writeZip(t, []zipEntry{{name: "app/main.py", body: "print('hello')"}})
+			},
+			wantFiles: files,
+		},
+		{
+			name:    "empty zip",
+			archive: func(t *testing.T) string { return writeZip(t, nil) },
+		},
+		{
+			name:      "tar",
+			archive:   func(t *testing.T) string { return writeTar(t, tarEntries, plain) },
+			wantFiles: files,
+		},
+		{
+			name:      "tar.gz",
+			archive:   func(t *testing.T) string { return writeTar(t, tarEntries, gzipped) },
+			wantFiles: files,
+		},
+		{
+			name:      "tar.zst",
+			archive:   func(t *testing.T) string { return writeTar(t, tarEntries, zstdCompressed) },
+			wantFiles: files,
+		},
+		{
+			name:    "text file",
+			archive: writeRaw("services:\n  web:\n    image: nginx\n"),
+			wantErr: ErrUnknownFormat,
+		},
+		{
+			name:    "empty file",
+			archive: writeRaw(""),
+			wantErr: ErrUnknownFormat,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destDir := t.TempDir()
+			err := Extract(test.archive(t), destDir, testLimits)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("Extract() error = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertFiles(t, destDir, test.wantFiles)
+		})
+	}
+}
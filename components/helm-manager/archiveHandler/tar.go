@@ -0,0 +1,81 @@
+package archiveHandler
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+)
+
+// tarExtractor estrae archivi tar, eventualmente compressi con decompress
+type tarExtractor struct {
+	decompress func(io.Reader) (io.ReadCloser, error)
+}
+
+func (t tarExtractor) Extract(archivePath string, destDir string, limits Limits) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var reader io.Reader = file
+	if t.decompress != nil {
+		decompressed, err := t.decompress(file)
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		reader = decompressed
+	}
+	tarReader := tar.NewReader(reader)
+	budget := &sizeBudget{limits: limits}
+	entries := 0
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		entries++
+		if entries > limits.MaxEntries {
+			return ErrTooManyEntries
+		}
+		err = extractTarEntry(header, tarReader, destDir, budget)
+		if err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+		// nel tar il rapporto di compressione si può controllare solo sull'intero archivio
+		if t.decompress != nil {
+			err = checkRatio(budget.written, info.Size(), limits.MaxCompressionRatio)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func extractTarEntry(header *tar.Header, tarReader *tar.Reader, destDir string, budget *sizeBudget) error {
+	target, err := safeJoin(destDir, header.Name)
+	if err != nil {
+		return err
+	}
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0755)
+	case tar.TypeReg, tar.TypeRegA:
+		_, err = budget.writeFile(target, tarReader, header.FileInfo().Mode())
+		return err
+	default:
+		// link simbolici, hard link e file speciali potrebbero puntare fuori dalla cartella
+		return ErrUnsupportedEntry
+	}
+}
@@ -0,0 +1,201 @@
+package archiveHandler
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type tarEntry struct {
+	name     string
+	body     string
+	typeflag byte
+	linkname string
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func plain(w io.Writer) io.WriteCloser {
+	return nopWriteCloser{w}
+}
+
+func gzipped(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+func zstdCompressed(w io.Writer) io.WriteCloser {
+	encoder, err := zstd.NewWriter(w)
+	if err != nil {
+		panic(err)
+	}
+	return encoder
+}
+
+// writeTar crea un archivio tar compresso con compress e ne restituisce il percorso
+func writeTar(t *testing.T, entries []tarEntry, compress func(io.Writer) io.WriteCloser) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "upload")
+	var buf bytes.Buffer
+	compressed := compress(&buf)
+	writer := tar.NewWriter(compressed)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Mode:     0644,
+			Size:     int64(len(entry.body)),
+			Format:   tar.FormatPAX,
+		}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		err := writer.WriteHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = writer.Write([]byte(entry.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = compressed.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(archivePath, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func TestExtractTar(t *testing.T) {
+	half := string(bytes.Repeat([]byte("a"), int(testLimits.MaxTotalSize/2+1)))
+	tests := []struct {
+		name      string
+		entries   []tarEntry
+		compress  func(io.Writer) io.WriteCloser
+		wantErr   error
+		wantFiles map[string]string
+	}{
+		{
+			name: "nested directories",
+			entries: []tarEntry{
+				{name: "app/", typeflag: tar.TypeDir},
+				{name: "app/src/main.py", body: "print('hello')"},
+				{name: "app/config/settings.yaml", body: "debug: false"},
+			},
+			wantFiles: map[string]string{
+				"app/src/main.py":          "print('hello')",
+				"app/config/settings.yaml": "debug: false",
+			},
+		},
+		{
+			name:    "path traversal",
+			entries: []tarEntry{{name: "app/../../evil.sh", body: "rm -rf /"}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "absolute path",
+			entries: []tarEntry{{name: "/etc/cron.d/evil", body: "* * * * * root true"}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "symlink",
+			entries: []tarEntry{{name: "passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}},
+			wantErr: ErrUnsupportedEntry,
+		},
+		{
+			name: "hard link",
+			entries: []tarEntry{
+				{name: "main.py", body: "print('hello')"},
+				{name: "passwd", typeflag: tar.TypeLink, linkname: "/etc/passwd"},
+			},
+			wantErr: ErrUnsupportedEntry,
+		},
+		{
+			name:    "character device",
+			entries: []tarEntry{{name: "null", typeflag: tar.TypeChar}},
+			wantErr: ErrUnsupportedEntry,
+		},
+		{
+			name:    "duplicate file",
+			entries: []tarEntry{{name: "main.py", body: "safe"}, {name: "main.py", body: "evil"}},
+			wantErr: ErrDuplicateEntry,
+		},
+		{
+			name:    "entry larger than the limit",
+			entries: []tarEntry{{name: "big", body: half + half}},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "entries larger than the limit",
+			entries: []tarEntry{{name: "first", body: half}, {name: "second", body: half}},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name: "too many entries",
+			entries: func() []tarEntry {
+				entries := make([]tarEntry, testLimits.MaxEntries+1)
+				for i := range entries {
+					entries[i] = tarEntry{name: string(rune('a'+i)) + ".txt"}
+				}
+				return entries
+			}(),
+			wantErr: ErrTooManyEntries,
+		},
+		{
+			name:     "gzip compression ratio",
+			entries:  []tarEntry{{name: "zeros", body: string(make([]byte, 512<<10))}},
+			compress: gzipped,
+			wantErr:  ErrCompressionRatio,
+		},
+		{
+			name:     "zstd compression ratio",
+			entries:  []tarEntry{{name: "zeros", body: string(make([]byte, 512<<10))}},
+			compress: zstdCompressed,
+			wantErr:  ErrCompressionRatio,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			compress := test.compress
+			if compress == nil {
+				compress = plain
+			}
+			destDir := t.TempDir()
+			err := Extract(writeTar(t, test.entries, compress), destDir, testLimits)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("Extract() error = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertFiles(t, destDir, test.wantFiles)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package archiveHandler
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+)
+
+// ExtractZip estrae l'archivio zip in destDir rispettando i limiti. Link simbolici e file
+// speciali vengono rifiutati, così come i percorsi che uscirebbero da destDir
+func ExtractZip(archivePath string, destDir string, limits Limits) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+	if len(zipReader.File) > limits.MaxEntries {
+		return ErrTooManyEntries
+	}
+	budget := &sizeBudget{limits: limits}
+	for _, file := range zipReader.File {
+		err = extractZipEntry(file, destDir, budget)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+func extractZipEntry(file *zip.File, destDir string, budget *sizeBudget) error {
+	target, err := safeJoin(destDir, file.Name)
+	if err != nil {
+		return err
+	}
+	mode := file.Mode()
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(target, 0755)
+	case !mode.IsRegular():
+		return ErrUnsupportedEntry
+	}
+	// controllo preliminare sulle dimensioni dichiarate, quelle reali vengono verificate durante la copia
+	err = checkRatio(int64(file.UncompressedSize64), int64(file.CompressedSize64), budget.limits.MaxCompressionRatio)
+	if err != nil {
+		return err
+	}
+	fileReader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer fileReader.Close()
+	written, err := budget.writeFile(target, fileReader, mode)
+	if err != nil {
+		return err
+	}
+	return checkRatio(written, int64(file.CompressedSize64), budget.limits.MaxCompressionRatio)
+}
@@ -0,0 +1,167 @@
+package archiveHandler
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type zipEntry struct {
+	name string
+	body string
+	mode os.FileMode
+	// zip.Store di default, così la dimensione compressa coincide con quella estratta
+	method uint16
+}
+
+// writeZip crea un archivio zip con le voci indicate e ne restituisce il percorso
+func writeZip(t *testing.T, entries []zipEntry) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "upload")
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: entry.method}
+		mode := entry.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header.SetMode(mode)
+		fileWriter, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fileWriter.Write([]byte(entry.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(archivePath, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func TestExtractZip(t *testing.T) {
+	half := string(bytes.Repeat([]byte("a"), int(testLimits.MaxTotalSize/2+1)))
+	tests := []struct {
+		name      string
+		entries   []zipEntry
+		wantErr   error
+		wantFiles map[string]string
+	}{
+		{
+			name: "nested directories",
+			entries: []zipEntry{
+				{name: "app/", mode: os.ModeDir | 0755},
+				{name: "app/src/main.py", body: "print('hello')"},
+				{name: "app/config/settings.yaml", body: "debug: false", method: zip.Deflate},
+			},
+			wantFiles: map[string]string{
+				"app/src/main.py":          "print('hello')",
+				"app/config/settings.yaml": "debug: false",
+			},
+		},
+		{
+			name:    "path traversal",
+			entries: []zipEntry{{name: "../evil.sh", body: "rm -rf /"}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "absolute path",
+			entries: []zipEntry{{name: "/etc/cron.d/evil", body: "* * * * * root true"}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "symlink",
+			entries: []zipEntry{{name: "passwd", body: "/etc/passwd", mode: os.ModeSymlink | 0777}},
+			wantErr: ErrUnsupportedEntry,
+		},
+		{
+			name:    "named pipe",
+			entries: []zipEntry{{name: "fifo", mode: os.ModeNamedPipe | 0644}},
+			wantErr: ErrUnsupportedEntry,
+		},
+		{
+			name:    "duplicate file",
+			entries: []zipEntry{{name: "main.py", body: "safe"}, {name: "main.py", body: "evil"}},
+			wantErr: ErrDuplicateEntry,
+		},
+		{
+			name:    "file over a directory",
+			entries: []zipEntry{{name: "app/", mode: os.ModeDir | 0755}, {name: "app", body: "evil"}},
+			wantErr: ErrDuplicateEntry,
+		},
+		{
+			name:    "entry larger than the limit",
+			entries: []zipEntry{{name: "big", body: half + half}},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "entries larger than the limit",
+			entries: []zipEntry{{name: "first", body: half}, {name: "second", body: half}},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name: "too many entries",
+			entries: func() []zipEntry {
+				entries := make([]zipEntry, testLimits.MaxEntries+1)
+				for i := range entries {
+					entries[i] = zipEntry{name: string(rune('a'+i)) + ".txt"}
+				}
+				return entries
+			}(),
+			wantErr: ErrTooManyEntries,
+		},
+		{
+			name:    "compression ratio",
+			entries: []zipEntry{{name: "zeros", body: string(make([]byte, 512<<10)), method: zip.Deflate}},
+			wantErr: ErrCompressionRatio,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destDir := t.TempDir()
+			err := ExtractZip(writeZip(t, test.entries), destDir, testLimits)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("ExtractZip() error = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertFiles(t, destDir, test.wantFiles)
+		})
+	}
+}
+
+func TestExtractZipPermissions(t *testing.T) {
+	destDir := t.TempDir()
+	archivePath := writeZip(t, []zipEntry{
+		{name: "run.sh", body: "#!/bin/sh", mode: os.ModeSetuid | 0755},
+		{name: "secret", body: "key", mode: 0600},
+	})
+	err := ExtractZip(archivePath, destDir, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{"run.sh": 0755, "secret": 0644} {
+		info, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want {
+			t.Errorf("mode of %s = %v, want %v", name, info.Mode(), want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package composeHandler
+
+import (
+	"errors"
+	"fmt"
+	"helm3-manager/models"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ErrInvalidCompose indica un file che non è un docker-compose.yml leggibile
+var ErrInvalidCompose = errors.New("invalid docker-compose file")
+
+// dimensione delle PersistentVolumeClaim create per i volumi con nome, che in docker-compose
+// non hanno una dimensione
+const defaultVolumeSize = "1Gi"
+
+var (
+	invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+	// $$, ${VAR}, ${VAR:-default} e le altre forme di docker-compose, $VAR
+	variablePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-?+])([^}]*))?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// converter accumula gli avvisi e i volumi persistenti mentre i servizi vengono convertiti
+type converter struct {
+	files fs.FS
+	// variabili del file .env caricato con l'archivio, usate per l'interpolazione
+	env         map[string]string
+	warnings    []models.Warning
+	fieldErrors []models.FieldError
+	// nome del servizio -> nome del componente
+	names             map[string]string
+	persistentVolumes map[string]bool
+}
+
+// Convert traduce un docker-compose.yml (specifica v3) nei values di PACKS. I bind mount e gli
+// env_file vengono cercati in files, i file caricati con l'archivio; le chiavi non supportate
+// vengono ignorate e restituite come avvisi. I values vanno comunque validati con lo schema
+func Convert(data []byte, files fs.FS) (map[string]interface{}, []models.Warning, error) {
+	var compose map[string]interface{}
+	err := yaml.Unmarshal(data, &compose)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidCompose, err.Error())
+	}
+	c := &converter{
+		files:             files,
+		env:               make(map[string]string),
+		warnings:          make([]models.Warning, 0),
+		fieldErrors:       make([]models.FieldError, 0),
+		names:             make(map[string]string),
+		persistentVolumes: make(map[string]bool),
+	}
+	c.loadDotEnv()
+	compose, _ = c.interpolate("", compose).(map[string]interface{})
+	services, ok := compose["services"].(map[string]interface{})
+	if !ok || len(services) == 0 {
+		return nil, nil, &models.ValidationError{Errors: []models.FieldError{{
+			Path:     "services",
+			Expected: "at least one service",
+			Got:      fmt.Sprint(compose["services"]),
+			Message:  "the file does not define any service",
+		}}}
+	}
+	for _, key := range sortedKeys(compose) {
+		switch {
+		case key == "services" || key == "volumes":
+		case key == "version" || key == "name" || strings.HasPrefix(key, "x-"):
+			// non cambiano il risultato della conversione
+		default:
+			c.warn(key, "top-level key %s is not supported and was ignored", key)
+		}
+	}
+	declaredVolumes, _ := compose["volumes"].(map[string]interface{})
+	serviceNames := sortedKeys(services)
+	c.assignNames(serviceNames)
+	components := make([]interface{}, 0, len(services))
+	for _, name := range serviceNames {
+		service, ok := services[name].(map[string]interface{})
+		if !ok {
+			service = make(map[string]interface{})
+		}
+		components = append(components, c.service(name, service, declaredVolumes))
+	}
+	c.resolveDependencies(components)
+	if len(c.fieldErrors) > 0 {
+		return nil, nil, &models.ValidationError{Errors: c.fieldErrors}
+	}
+	values := map[string]interface{}{"components": components}
+	if len(c.persistentVolumes) > 0 {
+		list := make([]interface{}, 0, len(c.persistentVolumes))
+		for _, name := range sortedKeys(c.persistentVolumes) {
+			list = append(list, map[string]interface{}{"name": name, "size": defaultVolumeSize})
+		}
+		values["persistentVolumes"] = list
+	}
+	return values, c.warnings, nil
+}
+
+func (c *converter) warn(path string, format string, args ...interface{}) {
+	c.warnings = append(c.warnings, models.Warning{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// assignNames sceglie per ogni servizio un nome di componente valido per Kubernetes, che è
+// anche il nome con cui gli altri componenti lo raggiungono
+func (c *converter) assignNames(serviceNames []string) {
+	used := make(map[string]bool)
+	for _, name := range serviceNames {
+		base := toDNSLabel(name, "service")
+		component := base
+		for i := 2; used[component]; i++ {
+			component = fmt.Sprintf("%s-%d", strings.TrimSuffix(base[:min(len(base), 48)], "-"), i)
+		}
+		used[component] = true
+		c.names[name] = component
+		if component != name {
+			c.warn("services."+name, "service %s was renamed to %s, other services must use this name to reach it", name, component)
+		}
+	}
+}
+
+// toDNSLabel adatta name ai nomi accettati dallo schema dei values
+func toDNSLabel(name string, fallback string) string {
+	label := invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	label = strings.Trim(label, "-")
+	if len(label) > 52 {
+		label = strings.TrimRight(label[:52], "-")
+	}
+	if label == "" {
+		return fallback
+	}
+	return label
+}
+
+// loadDotEnv legge le variabili del file .env, che docker-compose usa per l'interpolazione
+func (c *converter) loadDotEnv() {
+	data, err := fs.ReadFile(c.files, ".env")
+	if err != nil {
+		return
+	}
+	for name, value := range parseEnvFile(data) {
+		c.env[name] = value
+	}
+}
+
+// interpolate sostituisce le variabili nelle stringhe di value con i valori del file .env
+func (c *converter) interpolate(path string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			v[key] = c.interpolate(joinPath(path, key), v[key])
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = c.interpolate(joinPath(path, strconv.Itoa(i)), item)
+		}
+	case string:
+		return variablePattern.ReplaceAllStringFunc(v, func(match string) string {
+			if match == "$$" {
+				return "$"
+			}
+			groups := variablePattern.FindStringSubmatch(match)
+			name, operator, argument := groups[1], groups[2], groups[3]
+			if name == "" {
+				name = groups[4]
+			}
+			current, set := c.env[name]
+			switch operator {
+			case ":-":
+				if current == "" {
+					return argument
+				}
+			case "-":
+				if !set {
+					return argument
+				}
+			case ":+":
+				if current != "" {
+					return argument
+				}
+				return ""
+			case "+":
+				if set {
+					return argument
+				}
+				return ""
+			}
+			if !set {
+				c.warn(path, "variable %s is not defined in .env and was replaced with an empty string", name)
+			}
+			return current
+		})
+	}
+	return value
+}
+
+// parseEnvFile legge un file con righe NOME=valore, come .env e gli env_file di docker-compose
+func parseEnvFile(data []byte) map[string]string {
+	env := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[strings.TrimSpace(name)] = value
+	}
+	return env
+}
+
+// cleanArchivePath rende relativo alla cartella del docker-compose.yml un percorso del file,
+// restituendo false per i percorsi fuori dall'archivio caricato
+func cleanArchivePath(p string) (string, bool) {
+	if strings.HasPrefix(p, "/") || strings.HasPrefix(p, "~") {
+		return "", false
+	}
+	cleaned := path.Clean(p)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	if cleaned == "." {
+		return "", true
+	}
+	return cleaned, true
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// toString converte i valori scalari del yaml, i numeri arrivano come float64
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// toList accetta sia un valore singolo che una lista, come molte chiavi di docker-compose
+func toList(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	}
+	return []interface{}{value}
+}
@@ -0,0 +1,217 @@
+package composeHandler
+
+import (
+	"errors"
+	"helm3-manager/models"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"sigs.k8s.io/yaml"
+)
+
+// file caricati con l'archivio, cercati dagli env_file e dai bind mount
+var testFiles = fstest.MapFS{
+	".env":            {Data: []byte("TAG=1.25\nDB_PASSWORD=secret\n")},
+	"web.env":         {Data: []byte("MODE=production\nDEBUG=1\n")},
+	"site/index.html": {Data: []byte("hello")},
+	"nginx.conf":      {Data: []byte("events {}")},
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name         string
+		compose      string
+		wantValues   string
+		wantWarnings []models.Warning
+		wantErr      error
+		// percorso del models.FieldError atteso
+		wantFieldError string
+	}{
+		{
+			name: "services with files and volumes",
+			compose: `
+services:
+  web:
+    image: nginx:${TAG}
+    ports:
+    - "8080:80"
+    env_file: web.env
+    environment:
+      DEBUG: "0"
+    volumes:
+    - ./site:/usr/share/nginx/html
+    - ./nginx.conf:/etc/nginx/nginx.conf:ro
+    depends_on: [db]
+  db:
+    image: postgres:16
+    environment:
+    - POSTGRES_PASSWORD=${DB_PASSWORD}
+    expose: ["5432"]
+    volumes:
+    - db-data:/var/lib/postgresql/data
+    healthcheck:
+      test: ["CMD-SHELL", "pg_isready"]
+      interval: 10s
+      retries: 5
+volumes:
+  db-data: {}
+`,
+			wantValues: `
+components:
+- name: db
+  image: postgres:16
+  active: true
+  environment:
+  - name: POSTGRES_PASSWORD
+    value: secret
+  healthcheck:
+    interval: 10s
+    retries: 5
+    test: [CMD-SHELL, pg_isready]
+  ports:
+  - port: 5432
+    protocol: TCP
+  volumes:
+  - name: volume-0
+    mountPath: /var/lib/postgresql/data
+    persistentVolume: db-data
+- name: web
+  image: nginx:1.25
+  active: true
+  dependsOn: [db]
+  environment:
+  - name: DEBUG
+    value: "0"
+  - name: MODE
+    value: production
+  ports:
+  - port: 80
+    protocol: TCP
+  volumes:
+  - name: volume-0
+    mountPath: /usr/share/nginx/html
+    directory: site
+  - name: volume-1
+    mountPath: /etc/nginx/nginx.conf
+    file: nginx.conf
+persistentVolumes:
+- name: db-data
+  size: 1Gi
+`,
+			wantWarnings: []models.Warning{
+				{Path: "volumes.db-data", Message: "volume db-data is created with a size of 1Gi"},
+				{Path: "services.web.ports.0", Message: "published port 8080 is only reachable inside the release as web:80; use a port between 30000 and 32767 to publish it on the nodes"},
+				{Path: "services.web.volumes.1", Message: "read-only mounts are not supported, the volume is mounted read-write"},
+			},
+		},
+		{
+			name: "unsupported keys",
+			compose: `
+version: "3.8"
+networks:
+  default: {}
+services:
+  My_App:
+    image: busybox
+    command: ["sleep", "infinity"]
+    restart: "no"
+    privileged: true
+    env_file: missing.env
+    volumes:
+    - /var/run/docker.sock:/var/run/docker.sock
+    - ./data:/data
+    deploy:
+      replicas: 2
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 256M
+`,
+			wantValues: `
+components:
+- name: my-app
+  image: busybox
+  active: true
+  commands:
+  - command: sleep infinity
+  replicas: 2
+  resources:
+    limits:
+      cpu: "0.5"
+      memory: 256Mi
+  volumes:
+  - name: volume-1
+    mountPath: /data
+    directory: data
+`,
+			wantWarnings: []models.Warning{
+				{Path: "networks", Message: "top-level key networks is not supported and was ignored"},
+				{Path: "services.My_App", Message: "service My_App was renamed to my-app, other services must use this name to reach it"},
+				{Path: "services.My_App.privileged", Message: "key privileged is not supported and was ignored"},
+				{Path: "services.My_App.env_file.0", Message: "env file missing.env was not found in the uploaded archive and was ignored"},
+				{Path: "services.My_App.command", Message: "command replaces the entrypoint of the image and runs with /bin/bash -c, add the entrypoint if the image needs it"},
+				{Path: "services.My_App.volumes.0", Message: "/var/run/docker.sock is outside the uploaded archive and the volume was ignored"},
+				{Path: "services.My_App.volumes.1", Message: "./data was not found in the uploaded archive and is mounted as an empty directory"},
+				{Path: "services.My_App.restart", Message: "restart no is not supported, the containers of a component are always restarted; set restartPolicy in the values to run it as a job"},
+			},
+		},
+		{
+			name:    "not yaml",
+			compose: "services: [",
+			wantErr: ErrInvalidCompose,
+		},
+		{
+			name:           "no services",
+			compose:        "version: '3'\n",
+			wantFieldError: "services",
+		},
+		{
+			name: "build without image",
+			compose: `
+services:
+  web:
+    build: .
+`,
+			wantFieldError: "services.web.image",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, warnings, err := Convert([]byte(test.compose), testFiles)
+			if test.wantErr != nil || test.wantFieldError != "" {
+				var validationError *models.ValidationError
+				switch {
+				case test.wantErr != nil && !errors.Is(err, test.wantErr):
+					t.Errorf("Convert() error = %v, want %v", err, test.wantErr)
+				case test.wantFieldError != "" && (!errors.As(err, &validationError) || validationError.Errors[0].Path != test.wantFieldError):
+					t.Errorf("Convert() error = %v, want a field error on %s", err, test.wantFieldError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// i values vengono confrontati come li legge Helm, dopo la conversione in yaml
+			data, err := yaml.Marshal(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want interface{}
+			err = yaml.Unmarshal(data, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = yaml.Unmarshal([]byte(test.wantValues), &want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Convert() values =\n%s\nwant\n%s", data, test.wantValues)
+			}
+			if !reflect.DeepEqual(warnings, test.wantWarnings) {
+				t.Errorf("Convert() warnings = %v, want %v", warnings, test.wantWarnings)
+			}
+		})
+	}
+}
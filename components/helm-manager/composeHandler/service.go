@@ -0,0 +1,544 @@
+package composeHandler
+
+import (
+	"fmt"
+	"helm3-manager/models"
+	"io/fs"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	validEnvName = regexp.MustCompile(`^[-._a-zA-Z][-._a-zA-Z0-9]*$`)
+	// caratteri che non vanno racchiusi tra apici in un comando della shell
+	plainShellWord = regexp.MustCompile(`^[-A-Za-z0-9_@%+=:,./]+$`)
+	// dimensioni di docker-compose: byte o b, k, m, g con o senza b finale
+	composeMemory = regexp.MustCompile(`(?i)^([0-9]+(?:\.[0-9]+)?)\s*([bkmg]?)b?$`)
+)
+
+// chiavi dei servizi che la conversione traduce, le altre vengono riportate tra gli avvisi
+var serviceKeys = map[string]bool{
+	"image": true, "build": true, "ports": true, "expose": true, "environment": true,
+	"env_file": true, "command": true, "entrypoint": true, "volumes": true, "depends_on": true,
+	"healthcheck": true, "deploy": true, "restart": true, "scale": true, "cpus": true,
+	"mem_limit": true, "mem_reservation": true,
+}
+
+// service converte un servizio nel componente corrispondente
+func (c *converter) service(name string, service map[string]interface{}, declaredVolumes map[string]interface{}) map[string]interface{} {
+	path := "services." + name
+	component := map[string]interface{}{
+		"name":   c.names[name],
+		"active": true,
+	}
+	for _, key := range sortedKeys(service) {
+		if !serviceKeys[key] && !strings.HasPrefix(key, "x-") {
+			c.warn(joinPath(path, key), "key %s is not supported and was ignored", key)
+		}
+	}
+	image := toString(service["image"])
+	if image == "" {
+		message := "service " + name + " has no image"
+		if service["build"] != nil {
+			message += ": images built with build are not supported, push the image to a registry and set image"
+		}
+		c.fieldErrors = append(c.fieldErrors, models.FieldError{
+			Path:     joinPath(path, "image"),
+			Expected: "an image",
+			Got:      "",
+			Message:  message,
+		})
+	} else if service["build"] != nil {
+		c.warn(joinPath(path, "build"), "build is not supported, the image %s is used as is", image)
+	}
+	component["image"] = image
+	if ports := c.ports(path, service); len(ports) > 0 {
+		component["ports"] = ports
+	}
+	if environment := c.environment(path, service); len(environment) > 0 {
+		component["environment"] = environment
+	}
+	if commands := c.commands(path, service); len(commands) > 0 {
+		component["commands"] = commands
+	}
+	if volumes := c.volumes(path, service, declaredVolumes); len(volumes) > 0 {
+		component["volumes"] = volumes
+	}
+	if healthcheck := c.healthcheck(path, service["healthcheck"]); healthcheck != nil {
+		component["healthcheck"] = healthcheck
+	}
+	c.deploy(path, service, component)
+	if dependsOn := c.dependsOn(path, service["depends_on"]); len(dependsOn) > 0 {
+		component["dependsOn"] = dependsOn
+	}
+	switch restart := toString(service["restart"]); {
+	case restart == "" || restart == "always" || restart == "unless-stopped":
+	default:
+		c.warn(joinPath(path, "restart"), "restart %s is not supported, the containers of a component are always restarted; set restartPolicy in the values to run it as a job", restart)
+	}
+	return component
+}
+
+// ports unisce ports ed expose; solo le porte pubblicate tra 30000 e 32767 diventano NodePort
+func (c *converter) ports(path string, service map[string]interface{}) []interface{} {
+	ports := make([]interface{}, 0)
+	seen := make(map[string]bool)
+	add := func(itemPath string, target string, published string, protocol string) {
+		port, err := strconv.Atoi(target)
+		if err != nil || port < 1 || port > 65535 {
+			c.warn(itemPath, "port %s is not supported and was ignored, port ranges must be listed one by one", target)
+			return
+		}
+		protocol = strings.ToUpper(protocol)
+		if protocol == "" {
+			protocol = "TCP"
+		}
+		if protocol != "TCP" && protocol != "UDP" && protocol != "SCTP" {
+			c.warn(itemPath, "protocol %s is not supported and the port was ignored", protocol)
+			return
+		}
+		key := target + "/" + protocol
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		entry := map[string]interface{}{"port": port, "protocol": protocol}
+		if published != "" {
+			hostPort, err := strconv.Atoi(published)
+			if err == nil && hostPort >= 30000 && hostPort <= 32767 {
+				entry["hostPort"] = hostPort
+			} else {
+				c.warn(itemPath, "published port %s is only reachable inside the release as %s:%d; use a port between 30000 and 32767 to publish it on the nodes", published, c.names[strings.TrimPrefix(path, "services.")], port)
+			}
+		}
+		ports = append(ports, entry)
+	}
+	for i, item := range toList(service["ports"]) {
+		itemPath := fmt.Sprintf("%s.ports.%d", path, i)
+		if long, ok := item.(map[string]interface{}); ok {
+			for _, key := range sortedKeys(long) {
+				if key != "target" && key != "published" && key != "protocol" && key != "mode" {
+					c.warn(joinPath(itemPath, key), "key %s is not supported and was ignored", key)
+				}
+			}
+			add(itemPath, toString(long["target"]), toString(long["published"]), toString(long["protocol"]))
+			continue
+		}
+		spec, protocol, _ := strings.Cut(toString(item), "/")
+		parts := strings.Split(spec, ":")
+		published := ""
+		if len(parts) > 1 {
+			published = parts[len(parts)-2]
+		}
+		add(itemPath, parts[len(parts)-1], published, protocol)
+	}
+	for i, item := range toList(service["expose"]) {
+		target, protocol, _ := strings.Cut(toString(item), "/")
+		add(fmt.Sprintf("%s.expose.%d", path, i), target, "", protocol)
+	}
+	return ports
+}
+
+// environment unisce gli env_file e environment, che ha la precedenza come in docker-compose
+func (c *converter) environment(path string, service map[string]interface{}) []interface{} {
+	values := make(map[string]string)
+	for i, item := range toList(service["env_file"]) {
+		itemPath := fmt.Sprintf("%s.env_file.%d", path, i)
+		file, required := toString(item), true
+		if long, ok := item.(map[string]interface{}); ok {
+			file = toString(long["path"])
+			required = long["required"] != false
+		}
+		cleaned, ok := cleanArchivePath(file)
+		var data []byte
+		var err error
+		if ok {
+			data, err = fs.ReadFile(c.files, cleaned)
+		}
+		if !ok || err != nil {
+			if required {
+				c.warn(itemPath, "env file %s was not found in the uploaded archive and was ignored", file)
+			}
+			continue
+		}
+		for name, value := range parseEnvFile(data) {
+			values[name] = value
+		}
+	}
+	environmentPath := joinPath(path, "environment")
+	switch environment := service["environment"].(type) {
+	case map[string]interface{}:
+		for _, name := range sortedKeys(environment) {
+			value := environment[name]
+			if value == nil {
+				c.setFromDotEnv(joinPath(environmentPath, name), name, values)
+				continue
+			}
+			values[name] = toString(value)
+		}
+	case []interface{}:
+		for i, item := range environment {
+			name, value, found := strings.Cut(toString(item), "=")
+			if !found {
+				c.setFromDotEnv(fmt.Sprintf("%s.%d", environmentPath, i), name, values)
+				continue
+			}
+			values[name] = value
+		}
+	}
+	list := make([]interface{}, 0, len(values))
+	for _, name := range sortedKeys(values) {
+		if !validEnvName.MatchString(name) {
+			c.warn(environmentPath, "variable name %s is not valid in Kubernetes and was ignored", name)
+			continue
+		}
+		list = append(list, map[string]interface{}{"name": name, "value": values[name]})
+	}
+	return list
+}
+
+// le variabili senza valore prendono quello dell'ambiente di docker-compose, qui il file .env
+func (c *converter) setFromDotEnv(path string, name string, values map[string]string) {
+	value, ok := c.env[name]
+	if !ok {
+		c.warn(path, "variable %s has no value and is not defined in .env, it was ignored", name)
+		return
+	}
+	values[name] = value
+}
+
+// commands unisce entrypoint e command in un comando eseguito da /bin/bash -c come nel template
+func (c *converter) commands(path string, service map[string]interface{}) []interface{} {
+	command := shellCommand(service["command"])
+	entrypoint := shellCommand(service["entrypoint"])
+	if command == "" && entrypoint == "" {
+		return nil
+	}
+	if entrypoint == "" {
+		c.warn(joinPath(path, "command"), "command replaces the entrypoint of the image and runs with /bin/bash -c, add the entrypoint if the image needs it")
+	}
+	commands := make([]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(entrypoint+" "+command), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			commands = append(commands, map[string]interface{}{"command": line})
+		}
+	}
+	return commands
+}
+
+// shellCommand restituisce un comando di docker-compose come riga della shell
+func shellCommand(value interface{}) string {
+	args, ok := value.([]interface{})
+	if !ok {
+		return toString(value)
+	}
+	words := make([]string, 0, len(args))
+	for _, arg := range args {
+		word := toString(arg)
+		if !plainShellWord.MatchString(word) {
+			word = "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
+
+// volumes traduce i bind mount relativi in file o cartelle dell'archivio caricato e i volumi
+// con nome in volumi persistenti della release
+func (c *converter) volumes(path string, service map[string]interface{}, declaredVolumes map[string]interface{}) []interface{} {
+	volumes := make([]interface{}, 0)
+	for i, item := range toList(service["volumes"]) {
+		itemPath := fmt.Sprintf("%s.volumes.%d", path, i)
+		var kind, source, target string
+		readOnly := false
+		if long, ok := item.(map[string]interface{}); ok {
+			kind, source, target = toString(long["type"]), toString(long["source"]), toString(long["target"])
+			readOnly = long["read_only"] == true
+		} else {
+			parts := strings.Split(toString(item), ":")
+			target = parts[0]
+			if len(parts) > 1 {
+				source, target = parts[0], parts[1]
+			}
+			if len(parts) > 2 {
+				readOnly = strings.Contains(parts[2], "ro")
+			}
+			switch {
+			case source == "":
+				kind = "volume"
+			case strings.HasPrefix(source, ".") || strings.HasPrefix(source, "/") || strings.HasPrefix(source, "~"):
+				kind = "bind"
+			default:
+				kind = "volume"
+			}
+		}
+		if !strings.HasPrefix(target, "/") {
+			c.warn(itemPath, "mount path %s is not absolute and the volume was ignored", target)
+			continue
+		}
+		volume := map[string]interface{}{
+			"name":      fmt.Sprintf("volume-%d", i),
+			"mountPath": target,
+		}
+		switch kind {
+		case "bind":
+			cleaned, ok := cleanArchivePath(source)
+			if !ok {
+				c.warn(itemPath, "%s is outside the uploaded archive and the volume was ignored", source)
+				continue
+			}
+			info, err := fs.Stat(c.files, cleanedOrRoot(cleaned))
+			switch {
+			case err != nil:
+				c.warn(itemPath, "%s was not found in the uploaded archive and is mounted as an empty directory", source)
+				volume["directory"] = cleaned
+			case info.IsDir():
+				volume["directory"] = cleaned
+			default:
+				volume["file"] = cleaned
+			}
+		case "volume":
+			if source == "" {
+				c.warn(itemPath, "anonymous volumes are not supported and the volume was ignored, the data is kept in the container")
+				continue
+			}
+			name := toDNSLabel(source, "volume")
+			if !c.persistentVolumes[name] {
+				if declared, ok := declaredVolumes[source].(map[string]interface{}); ok && len(declared) > 0 {
+					c.warn("volumes."+source, "the options of volume %s are not supported and were ignored", source)
+				}
+				c.warn("volumes."+source, "volume %s is created with a size of %s", source, defaultVolumeSize)
+			}
+			c.persistentVolumes[name] = true
+			volume["persistentVolume"] = name
+		default:
+			c.warn(itemPath, "volumes of type %s are not supported and the volume was ignored", kind)
+			continue
+		}
+		if readOnly {
+			c.warn(itemPath, "read-only mounts are not supported, the volume is mounted read-write")
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes
+}
+
+func cleanedOrRoot(p string) string {
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// healthcheck traduce il controllo di docker-compose in quello dei values, che ha gli stessi campi
+func (c *converter) healthcheck(path string, value interface{}) map[string]interface{} {
+	healthcheck, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	path = joinPath(path, "healthcheck")
+	if healthcheck["disable"] == true {
+		return map[string]interface{}{"disable": true}
+	}
+	result := make(map[string]interface{})
+	for _, key := range sortedKeys(healthcheck) {
+		switch key {
+		case "test":
+			test := healthcheck["test"]
+			if list, ok := test.([]interface{}); ok {
+				first := ""
+				if len(list) > 0 {
+					first = toString(list[0])
+				}
+				if first != "CMD" && first != "CMD-SHELL" && first != "NONE" {
+					c.warn(joinPath(path, "test"), "test must start with CMD, CMD-SHELL or NONE, the healthcheck was ignored")
+					return nil
+				}
+				args := make([]interface{}, 0, len(list))
+				for _, item := range list {
+					args = append(args, toString(item))
+				}
+				test = args
+			}
+			result["test"] = test
+		case "interval", "timeout", "start_period":
+			seconds, err := c.duration(joinPath(path, key), toString(healthcheck[key]))
+			if err != nil {
+				continue
+			}
+			if key == "start_period" {
+				key = "startPeriod"
+			}
+			result[key] = seconds
+		case "retries":
+			result["retries"] = healthcheck["retries"]
+		case "disable":
+		default:
+			c.warn(joinPath(path, key), "key %s is not supported and was ignored", key)
+		}
+	}
+	if result["test"] == nil {
+		c.warn(path, "healthcheck without test uses the check of the image, which Kubernetes does not run; the healthcheck was ignored")
+		return nil
+	}
+	return result
+}
+
+// duration arrotonda per eccesso ai secondi una durata di docker-compose come 1m30s o 500ms
+func (c *converter) duration(path string, value string) (string, error) {
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		c.warn(path, "duration %s is not valid and was ignored", value)
+		return "", fmt.Errorf("invalid duration %s", value)
+	}
+	seconds := int64(math.Ceil(parsed.Seconds()))
+	if time.Duration(seconds)*time.Second != parsed {
+		c.warn(path, "duration %s was rounded up to %ds", value, seconds)
+	}
+	return fmt.Sprintf("%ds", max(seconds, 1)), nil
+}
+
+// deploy legge repliche e risorse sia da deploy che dalle chiavi equivalenti dei servizi
+func (c *converter) deploy(path string, service map[string]interface{}, component map[string]interface{}) {
+	deploy, _ := service["deploy"].(map[string]interface{})
+	deployPath := joinPath(path, "deploy")
+	replicas := service["scale"]
+	if deploy["replicas"] != nil {
+		replicas = deploy["replicas"]
+	}
+	if replicas != nil {
+		component["replicas"] = replicas
+	}
+	limits := make(map[string]interface{})
+	requests := make(map[string]interface{})
+	resources, _ := deploy["resources"].(map[string]interface{})
+	for _, key := range sortedKeys(resources) {
+		var target map[string]interface{}
+		switch key {
+		case "limits":
+			target = limits
+		case "reservations":
+			target = requests
+		default:
+			c.warn(joinPath(deployPath, "resources."+key), "key %s is not supported and was ignored", key)
+			continue
+		}
+		values, _ := resources[key].(map[string]interface{})
+		for _, name := range sortedKeys(values) {
+			c.resource(joinPath(deployPath, "resources."+key+"."+name), name, values[name], target)
+		}
+	}
+	c.resource(joinPath(path, "cpus"), "cpus", service["cpus"], limits)
+	c.resource(joinPath(path, "mem_limit"), "memory", service["mem_limit"], limits)
+	c.resource(joinPath(path, "mem_reservation"), "memory", service["mem_reservation"], requests)
+	if len(limits) > 0 || len(requests) > 0 {
+		result := make(map[string]interface{})
+		if len(limits) > 0 {
+			result["limits"] = limits
+		}
+		if len(requests) > 0 {
+			result["requests"] = requests
+		}
+		component["resources"] = result
+	}
+	for _, key := range sortedKeys(deploy) {
+		if key != "replicas" && key != "resources" {
+			c.warn(joinPath(deployPath, key), "key %s is not supported and was ignored", key)
+		}
+	}
+}
+
+// resource converte cpus e memory di docker-compose nelle quantità di Kubernetes
+func (c *converter) resource(path string, name string, value interface{}, target map[string]interface{}) {
+	if value == nil {
+		return
+	}
+	switch name {
+	case "cpus":
+		cpu, err := strconv.ParseFloat(toString(value), 64)
+		if err != nil || cpu <= 0 {
+			c.warn(path, "cpus %s is not valid and was ignored", toString(value))
+			return
+		}
+		target["cpu"] = strconv.FormatFloat(cpu, 'f', -1, 64)
+	case "memory":
+		match := composeMemory.FindStringSubmatch(toString(value))
+		if match == nil {
+			c.warn(path, "memory %s is not valid and was ignored", toString(value))
+			return
+		}
+		units := map[string]string{"": "", "b": "", "k": "Ki", "m": "Mi", "g": "Gi"}
+		target["memory"] = match[1] + units[strings.ToLower(match[2])]
+	default:
+		c.warn(path, "resource %s is not supported and was ignored", name)
+	}
+}
+
+// dependsOn accetta sia la lista di servizi che la forma con le condizioni
+func (c *converter) dependsOn(path string, value interface{}) []interface{} {
+	path = joinPath(path, "depends_on")
+	services := make([]string, 0)
+	switch dependsOn := value.(type) {
+	case []interface{}:
+		for _, item := range dependsOn {
+			services = append(services, toString(item))
+		}
+	case map[string]interface{}:
+		for _, name := range sortedKeys(dependsOn) {
+			options, _ := dependsOn[name].(map[string]interface{})
+			if condition := toString(options["condition"]); condition == "service_completed_successfully" {
+				c.warn(joinPath(path, name), "condition %s is not supported, the component waits for %s to accept connections", condition, name)
+			}
+			services = append(services, name)
+		}
+	}
+	list := make([]interface{}, 0, len(services))
+	for _, service := range services {
+		name, ok := c.names[service]
+		if !ok {
+			c.warn(path, "service %s is not defined and was ignored", service)
+			continue
+		}
+		list = append(list, name)
+	}
+	return list
+}
+
+// resolveDependencies toglie da dependsOn i componenti senza porte TCP, che non possono essere
+// attesi dagli init container
+func (c *converter) resolveDependencies(components []interface{}) {
+	services := make(map[string]string)
+	for service, name := range c.names {
+		services[name] = service
+	}
+	withTCPPort := make(map[string]bool)
+	for _, item := range components {
+		component := item.(map[string]interface{})
+		ports, _ := component["ports"].([]interface{})
+		for _, port := range ports {
+			if port.(map[string]interface{})["protocol"] == "TCP" {
+				withTCPPort[component["name"].(string)] = true
+			}
+		}
+	}
+	for _, item := range components {
+		component := item.(map[string]interface{})
+		dependsOn, _ := component["dependsOn"].([]interface{})
+		kept := make([]interface{}, 0, len(dependsOn))
+		for _, dependency := range dependsOn {
+			if !withTCPPort[dependency.(string)] {
+				c.warn("services."+services[component["name"].(string)]+".depends_on", "%s has no TCP port to wait for and was removed from depends_on", dependency)
+				continue
+			}
+			kept = append(kept, dependency)
+		}
+		if len(kept) == 0 {
+			delete(component, "dependsOn")
+		} else {
+			component["dependsOn"] = kept
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// Config raccoglie tutti i parametri di helm-manager. I valori vengono letti, in ordine di priorità,
+// dalle variabili d'ambiente, dal file yaml indicato da CONFIG_FILE e dai default
+type Config struct {
+	ListenAddr        string `json:"listenAddr"`
+	UploadDir         string `json:"uploadDir"`
+	MaxReleasePerUser int    `json:"maxReleasePerUser"`
+	MaxArchiveSize    int64  `json:"maxArchiveSize"`
+	MaxValuesSize     int64  `json:"maxValuesSize"`
+	// quote di default degli utenti, sostituite da quelle salvate su Redis per l'utente o i suoi corsi
+	MaxActiveReleasesPerUser int    `json:"maxActiveReleasesPerUser"`
+	MaxReplicasPerUser       int    `json:"maxReplicasPerUser"`
+	MaxCPUPerUser            string `json:"maxCpuPerUser"`
+	MaxMemoryPerUser         string `json:"maxMemoryPerUser"`
+	// limiti sull'estrazione degli archivi caricati
+	MaxExtractedSize    int64 `json:"maxExtractedSize"`
+	MaxArchiveEntries   int   `json:"maxArchiveEntries"`
+	MaxCompressionRatio int64 `json:"maxCompressionRatio"`
+	// durata massima delle richieste che non restano aperte in streaming (log e terminale)
+	RequestTimeoutSeconds int `json:"requestTimeoutSeconds"`
+	// tempo concesso alle richieste in corso per terminare quando il server viene fermato
+	ShutdownTimeoutSeconds int `json:"shutdownTimeoutSeconds"`
+	// immagine degli init container che attendono i componenti indicati in dependsOn
+	WaitImage string `json:"waitImage"`
+	// cf che hanno sempre il ruolo admin, indipendentemente dal ruolo salvato su Redis
+	Admins          []string        `json:"admins"`
+	Session         SessionConfig   `json:"session"`
+	Files           FilesConfig     `json:"files"`
+	Redis           RedisConfig     `json:"redis"`
+	NamespacePolicy NamespacePolicy `json:"namespacePolicy"`
+}
+
+// knownSampleHMACKey è la chiave di esempio dei values del chart, nota a chiunque
+const knownSampleHMACKey = "a secret of at least 32 bytes used to sign helm-manager sessions"
+
+// SessionConfig contiene le chiavi dei token di sessione firmati da helm-manager. Con HS256 basta
+// HMACKey; con RS256 SigningKeyFile è la chiave privata PEM usata per firmare, identificata da
+// SigningKeyID, e VerificationKeysDir contiene le chiavi pubbliche <kid>.pem ancora accettate,
+// così una chiave può essere sostituita senza invalidare i token già emessi. MaxAgeSeconds è
+// la durata massima dal login, controllata in verifica con il claim orig_iat
+type SessionConfig struct {
+	Algorithm           string `json:"algorithm"`
+	TTLSeconds          int    `json:"ttlSeconds"`
+	MaxAgeSeconds       int    `json:"maxAgeSeconds"`
+	HMACKey             string `json:"hmacKey"`
+	SigningKeyFile      string `json:"signingKeyFile"`
+	SigningKeyID        string `json:"signingKeyId"`
+	VerificationKeysDir string `json:"verificationKeysDir"`
+}
+
+// Enabled indica se sono state configurate le chiavi, altrimenti valgono solo le sessioni della UI
+func (s SessionConfig) Enabled() bool {
+	return s.HMACKey != "" || s.SigningKeyFile != ""
+}
+
+// FilesConfig indica come vengono resi disponibili ai pod delle release i file caricati: i file
+// fino a InlineMaxFileSize diventano ConfigMap o Secret, le cartelle e i file più grandi vengono
+// copiati da un init container in una PersistentVolumeClaim della release, scaricandoli da
+// BaseURL, l'indirizzo di helm-manager raggiungibile dai namespace delle release
+type FilesConfig struct {
+	InlineMaxFileSize int64  `json:"inlineMaxFileSize"`
+	BaseURL           string `json:"baseUrl"`
+	InitImage         string `json:"initImage"`
+	ClaimSize         string `json:"claimSize"`
+	// vuota per usare la storage class di default del cluster
+	StorageClass string `json:"storageClass"`
+	// con più nodi serve ReadWriteMany perché i pod di una release possono essere su nodi diversi
+	AccessMode string `json:"accessMode"`
+}
+
+type RedisConfig struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	TLS      bool   `json:"tls"`
+	// numero massimo di connessioni aperte verso Redis
+	PoolSize int `json:"poolSize"`
+	// solo per ambienti di test con certificati self-signed
+	TLSInsecureSkipVerify bool `json:"tlsInsecureSkipVerify"`
+}
+
+// IsAdmin indica se il cf è tra gli amministratori configurati
+func (c *Config) IsAdmin(cf string) bool {
+	for _, admin := range c.Admins {
+		if admin == cf {
+			return true
+		}
+	}
+	return false
+}
+
+func (r RedisConfig) Addr() string {
+	return r.Host + ":" + r.Port
+}
+
+// NamespacePolicy contiene i limiti applicati ad ogni namespace di una release
+type NamespacePolicy struct {
+	QuotaCPU             string `json:"quotaCpu"`
+	QuotaMemory          string `json:"quotaMemory"`
+	QuotaPods            string `json:"quotaPods"`
+	DefaultCPURequest    string `json:"defaultCpuRequest"`
+	DefaultMemoryRequest string `json:"defaultMemoryRequest"`
+	DefaultCPULimit      string `json:"defaultCpuLimit"`
+	DefaultMemoryLimit   string `json:"defaultMemoryLimit"`
+	// CIDR dei pod del cluster, esclusi dalla regola che ammette il traffico esterno (NodePort);
+	// senza CIDR il traffico esterno non viene ammesso
+	PodCIDRs []string `json:"podCidrs"`
+}
+
+func Default() *Config {
+	return &Config{
+		ListenAddr:               ":9000",
+		UploadDir:                "/shared/uploads",
+		MaxReleasePerUser:        2,
+		MaxArchiveSize:           10 << 20,
+		MaxValuesSize:            2 << 20,
+		MaxActiveReleasesPerUser: 2,
+		MaxReplicasPerUser:       10,
+		MaxCPUPerUser:            "4",
+		MaxMemoryPerUser:         "8Gi",
+		MaxExtractedSize:         100 << 20,
+		MaxArchiveEntries:        1000,
+		MaxCompressionRatio:      100,
+		RequestTimeoutSeconds:    30,
+		ShutdownTimeoutSeconds:   30,
+		WaitImage:                "busybox:1.36",
+		Admins:                   []string{"admin"},
+		Session: SessionConfig{
+			Algorithm:     "HS256",
+			TTLSeconds:    3600,
+			MaxAgeSeconds: 43200,
+		},
+		Files: FilesConfig{
+			InlineMaxFileSize: 64 << 10,
+			BaseURL:           "http://helm-manager:9000",
+			InitImage:         "busybox:1.36",
+			ClaimSize:         "1Gi",
+			AccessMode:        "ReadWriteOnce",
+		},
+		Redis: RedisConfig{
+			Host:     "redis",
+			Port:     "6379",
+			PoolSize: 20,
+		},
+		NamespacePolicy: NamespacePolicy{
+			QuotaCPU:             "2",
+			QuotaMemory:          "4Gi",
+			QuotaPods:            "20",
+			DefaultCPURequest:    "100m",
+			DefaultMemoryRequest: "128Mi",
+			DefaultCPULimit:      "500m",
+			DefaultMemoryLimit:   "512Mi",
+		},
+	}
+}
+
+// Load costruisce la configurazione e la valida, va chiamata una sola volta all'avvio
+func Load() (*Config, error) {
+	conf := Default()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		err := conf.loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := conf.loadEnv()
+	if err != nil {
+		return nil, err
+	}
+	err = conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Println("Could not read config file", err)
+		return err
+	}
+	err = yaml.UnmarshalStrict(data, c)
+	if err != nil {
+		log.Println("Could not parse config file", err)
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	setString(&c.ListenAddr, "LISTEN_ADDR")
+	setString(&c.UploadDir, "UPLOAD_DIR")
+	setString(&c.MaxCPUPerUser, "MAX_CPU_PER_USER")
+	setString(&c.MaxMemoryPerUser, "MAX_MEMORY_PER_USER")
+	setString(&c.WaitImage, "WAIT_IMAGE")
+	setString(&c.Session.Algorithm, "SESSION_ALGORITHM")
+	setString(&c.Session.HMACKey, "SESSION_HMAC_KEY")
+	setString(&c.Session.SigningKeyFile, "SESSION_SIGNING_KEY_FILE")
+	setString(&c.Session.SigningKeyID, "SESSION_SIGNING_KEY_ID")
+	setString(&c.Session.VerificationKeysDir, "SESSION_VERIFICATION_KEYS_DIR")
+	setString(&c.Files.BaseURL, "FILES_BASE_URL")
+	setString(&c.Files.InitImage, "FILES_INIT_IMAGE")
+	setString(&c.Files.ClaimSize, "FILES_CLAIM_SIZE")
+	setString(&c.Files.StorageClass, "FILES_STORAGE_CLASS")
+	setString(&c.Files.AccessMode, "FILES_ACCESS_MODE")
+	setString(&c.Redis.Host, "REDIS_HOST")
+	setString(&c.Redis.Port, "REDIS_PORT")
+	setString(&c.Redis.Password, "REDIS_PASSWORD")
+	setString(&c.NamespacePolicy.QuotaCPU, "NAMESPACE_QUOTA_CPU")
+	setString(&c.NamespacePolicy.QuotaMemory, "NAMESPACE_QUOTA_MEMORY")
+	setString(&c.NamespacePolicy.QuotaPods, "NAMESPACE_QUOTA_PODS")
+	setString(&c.NamespacePolicy.DefaultCPURequest, "NAMESPACE_DEFAULT_CPU_REQUEST")
+	setString(&c.NamespacePolicy.DefaultMemoryRequest, "NAMESPACE_DEFAULT_MEMORY_REQUEST")
+	setString(&c.NamespacePolicy.DefaultCPULimit, "NAMESPACE_DEFAULT_CPU_LIMIT")
+	setString(&c.NamespacePolicy.DefaultMemoryLimit, "NAMESPACE_DEFAULT_MEMORY_LIMIT")
+	if admins := os.Getenv("ADMINS"); admins != "" {
+		c.Admins = strings.Split(admins, ",")
+	}
+	if cidrs := os.Getenv("NAMESPACE_POD_CIDRS"); cidrs != "" {
+		c.NamespacePolicy.PodCIDRs = strings.Split(cidrs, ",")
+	}
+	var errs []string
+	for key, field := range map[string]*int{
+		"MAX_RELEASE_PER_USER":         &c.MaxReleasePerUser,
+		"MAX_ACTIVE_RELEASES_PER_USER": &c.MaxActiveReleasesPerUser,
+		"MAX_REPLICAS_PER_USER":        &c.MaxReplicasPerUser,
+		"REDIS_DB":                     &c.Redis.DB,
+		"MAX_ARCHIVE_ENTRIES":          &c.MaxArchiveEntries,
+		"REQUEST_TIMEOUT_SECONDS":      &c.RequestTimeoutSeconds,
+		"SHUTDOWN_TIMEOUT_SECONDS":     &c.ShutdownTimeoutSeconds,
+		"REDIS_POOL_SIZE":              &c.Redis.PoolSize,
+		"SESSION_TTL_SECONDS":          &c.Session.TTLSeconds,
+		"SESSION_MAX_AGE_SECONDS":      &c.Session.MaxAgeSeconds,
+	} {
+		if err := setInt(field, key); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for key, field := range map[string]*int64{
+		"MAX_ARCHIVE_SIZE":           &c.MaxArchiveSize,
+		"MAX_VALUES_SIZE":            &c.MaxValuesSize,
+		"MAX_EXTRACTED_SIZE":         &c.MaxExtractedSize,
+		"MAX_COMPRESSION_RATIO":      &c.MaxCompressionRatio,
+		"FILES_INLINE_MAX_FILE_SIZE": &c.Files.InlineMaxFileSize,
+	} {
+		if err := setInt64(field, key); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for key, field := range map[string]*bool{
+		"REDIS_TLS":                      &c.Redis.TLS,
+		"REDIS_TLS_INSECURE_SKIP_VERIFY": &c.Redis.TLSInsecureSkipVerify,
+	} {
+		if err := setBool(field, key); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Validate controlla che la configurazione sia utilizzabile, riportando tutti i problemi insieme
+func (c *Config) Validate() error {
+	var errs []string
+	if c.ListenAddr == "" {
+		errs = append(errs, "listenAddr must not be empty")
+	}
+	if !filepath.IsAbs(c.UploadDir) {
+		errs = append(errs, "uploadDir must be an absolute path")
+	}
+	if c.MaxReleasePerUser < 1 {
+		errs = append(errs, "maxReleasePerUser must be at least 1")
+	}
+	if c.MaxActiveReleasesPerUser < 1 || c.MaxReplicasPerUser < 1 {
+		errs = append(errs, "maxActiveReleasesPerUser and maxReplicasPerUser must be at least 1")
+	}
+	for name, value := range map[string]string{
+		"maxCpuPerUser":    c.MaxCPUPerUser,
+		"maxMemoryPerUser": c.MaxMemoryPerUser,
+	} {
+		if _, err := resource.ParseQuantity(value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+		}
+	}
+	if c.MaxArchiveSize <= 0 || c.MaxValuesSize <= 0 {
+		errs = append(errs, "maxArchiveSize and maxValuesSize must be positive")
+	}
+	if c.MaxExtractedSize <= 0 || c.MaxArchiveEntries <= 0 || c.MaxCompressionRatio <= 0 {
+		errs = append(errs, "maxExtractedSize, maxArchiveEntries and maxCompressionRatio must be positive")
+	}
+	if c.RequestTimeoutSeconds <= 0 || c.ShutdownTimeoutSeconds <= 0 {
+		errs = append(errs, "requestTimeoutSeconds and shutdownTimeoutSeconds must be positive")
+	}
+	switch c.Session.Algorithm {
+	case "HS256":
+		// una chiave corta renderebbe i token falsificabili con un attacco a forza bruta
+		if c.Session.HMACKey != "" && len(c.Session.HMACKey) < 32 {
+			errs = append(errs, "session.hmacKey must be at least 32 bytes long")
+		}
+		// chiave di esempio pubblicata nelle versioni precedenti del chart
+		if c.Session.HMACKey == knownSampleHMACKey {
+			errs = append(errs, "session.hmacKey must not be the sample key of the chart")
+		}
+	case "RS256":
+		if c.Session.SigningKeyFile == "" || c.Session.SigningKeyID == "" {
+			errs = append(errs, "session.signingKeyFile and session.signingKeyId are required with RS256")
+		}
+	default:
+		errs = append(errs, "session.algorithm must be HS256 or RS256")
+	}
+	if c.Session.TTLSeconds <= 0 {
+		errs = append(errs, "session.ttlSeconds must be positive")
+	}
+	if c.Session.MaxAgeSeconds < c.Session.TTLSeconds {
+		errs = append(errs, "session.maxAgeSeconds must be at least session.ttlSeconds")
+	}
+	// i file inline finiscono tutti in una ConfigMap, che non può superare 1MiB
+	if c.Files.InlineMaxFileSize < 0 || c.Files.InlineMaxFileSize > 512<<10 {
+		errs = append(errs, "files.inlineMaxFileSize must be between 0 and 524288")
+	}
+	if c.Files.BaseURL == "" || c.Files.InitImage == "" {
+		errs = append(errs, "files.baseUrl and files.initImage must not be empty")
+	}
+	if _, err := resource.ParseQuantity(c.Files.ClaimSize); err != nil {
+		errs = append(errs, fmt.Sprintf("files.claimSize: %s", err.Error()))
+	}
+	if c.Files.AccessMode != "ReadWriteOnce" && c.Files.AccessMode != "ReadWriteMany" {
+		errs = append(errs, "files.accessMode must be ReadWriteOnce or ReadWriteMany")
+	}
+	if c.WaitImage == "" {
+		errs = append(errs, "waitImage must not be empty")
+	}
+	if c.Redis.Host == "" || c.Redis.Port == "" {
+		errs = append(errs, "redis host and port must not be empty")
+	}
+	if c.Redis.DB < 0 {
+		errs = append(errs, "redis db must not be negative")
+	}
+	if c.Redis.PoolSize < 1 {
+		errs = append(errs, "redis poolSize must be at least 1")
+	}
+	for name, value := range map[string]string{
+		"quotaCpu":             c.NamespacePolicy.QuotaCPU,
+		"quotaMemory":          c.NamespacePolicy.QuotaMemory,
+		"quotaPods":            c.NamespacePolicy.QuotaPods,
+		"defaultCpuRequest":    c.NamespacePolicy.DefaultCPURequest,
+		"defaultMemoryRequest": c.NamespacePolicy.DefaultMemoryRequest,
+		"defaultCpuLimit":      c.NamespacePolicy.DefaultCPULimit,
+		"defaultMemoryLimit":   c.NamespacePolicy.DefaultMemoryLimit,
+	} {
+		if _, err := resource.ParseQuantity(value); err != nil {
+			errs = append(errs, fmt.Sprintf("namespacePolicy.%s: %s", name, err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func setString(field *string, key string) {
+	if value := os.Getenv(key); value != "" {
+		*field = value
+	}
+}
+
+func setInt(field *int, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not an integer", key, value)
+	}
+	*field = parsed
+	return nil
+}
+
+func setInt64(field *int64, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not an integer", key, value)
+	}
+	*field = parsed
+	return nil
+}
+
+func setBool(field *bool, key string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not a boolean", key, value)
+	}
+	*field = parsed
+	return nil
+}
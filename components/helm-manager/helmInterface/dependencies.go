@@ -0,0 +1,114 @@
+package helmInterface
+
+import (
+	"fmt"
+	"helm3-manager/models"
+	"slices"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// validateDependencies controlla che i componenti in dependsOn esistano, abbiano una porta TCP
+// su cui attenderli e non formino cicli, che bloccherebbero per sempre gli init container;
+// values deve rispettare già lo schema
+func validateDependencies(values chartutil.Values) []models.FieldError {
+	fieldErrors := make([]models.FieldError, 0)
+	components, _ := values["components"].([]interface{})
+	names := make([]string, 0, len(components))
+	withTCPPort := make(map[string]bool)
+	dependencies := make(map[string][]string)
+	for _, item := range components {
+		component := item.(map[string]interface{})
+		name := fmt.Sprint(component["name"])
+		names = append(names, name)
+		ports, _ := component["ports"].([]interface{})
+		for _, port := range ports {
+			// come nel template, una porta senza protocol è TCP
+			protocol, ok := port.(map[string]interface{})["protocol"]
+			if !ok || protocol == "TCP" {
+				withTCPPort[name] = true
+			}
+		}
+	}
+	for i, item := range components {
+		component := item.(map[string]interface{})
+		name := names[i]
+		dependsOn, _ := component["dependsOn"].([]interface{})
+		for j, d := range dependsOn {
+			dependency := fmt.Sprint(d)
+			path := fmt.Sprintf("components.%d.dependsOn.%d", i, j)
+			switch {
+			case dependency == name:
+				fieldErrors = append(fieldErrors, models.FieldError{
+					Path:     path,
+					Expected: "the name of another component",
+					Got:      dependency,
+					Message:  "component " + name + " cannot depend on itself",
+				})
+			case !slices.Contains(names, dependency):
+				fieldErrors = append(fieldErrors, models.FieldError{
+					Path:     path,
+					Expected: "a name defined in components",
+					Got:      dependency,
+					Message:  "component " + dependency + " is not defined",
+				})
+			case !withTCPPort[dependency]:
+				fieldErrors = append(fieldErrors, models.FieldError{
+					Path:     path,
+					Expected: "a component with a TCP port",
+					Got:      dependency,
+					Message:  "component " + dependency + " has no TCP port to wait for",
+				})
+			default:
+				dependencies[name] = append(dependencies[name], dependency)
+			}
+		}
+	}
+	for i, cycle := range dependencyCycles(names, dependencies) {
+		fieldErrors = append(fieldErrors, models.FieldError{
+			Path:     fmt.Sprintf("components.%d.dependsOn", slices.Index(names, cycle[0])),
+			Expected: "dependencies without cycles",
+			Got:      strings.Join(cycle, " -> "),
+			Message:  fmt.Sprintf("dependency cycle %d: %s", i+1, strings.Join(cycle, " -> ")),
+		})
+	}
+	return fieldErrors
+}
+
+// dependencyCycles restituisce i cicli trovati con una visita in profondità, ognuno come
+// elenco di componenti che termina con il primo; i componenti sono visitati in ordine, così
+// gli errori sono sempre gli stessi per gli stessi values
+func dependencyCycles(names []string, dependencies map[string][]string) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	cycles := make([][]string, 0)
+	stack := make([]string, 0)
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dependency := range dependencies[name] {
+			switch state[dependency] {
+			case unvisited:
+				visit(dependency)
+			case visiting:
+				start := slices.Index(stack, dependency)
+				cycle := append(append([]string{}, stack[start:]...), dependency)
+				cycles = append(cycles, cycle)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+	}
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return cycles
+}
@@ -1,35 +1,161 @@
 package helmInterface
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"helm3-manager/models"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/xeipuuv/gojsonschema"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chartutil"
-	"helm.sh/helm/v3/pkg/cli"
-	"helm.sh/helm/v3/pkg/kube"
 	"helm.sh/helm/v3/pkg/release"
-	"k8s.io/client-go/kubernetes"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
-func GetNewHelmClient(namespace string, kube_client_set *kubernetes.Clientset, kube_config string) (*action.Configuration, error) {
-	actions_settings := cli.New()
-	actions_settings.KubeConfig = kube_config
+// Deployer installa e gestisce le release Helm nel namespace indicato
+type Deployer interface {
+	Install(ctx context.Context, chart *chart.Chart, values map[string]interface{}, releaseName string, namespace string) error
+	Upgrade(ctx context.Context, chart *chart.Chart, values map[string]interface{}, releaseName string, namespace string) error
+	Uninstall(releaseName string, namespace string) error
+	IsReleaseActive(releaseName string, namespace string) (bool, error)
+	GetHistory(releaseName string, namespace string) ([]*release.Release, error)
+	GetCurrentRevision(releaseName string, namespace string) (int, error)
+	Rollback(releaseName string, namespace string, revision int) error
+	// ForgetNamespace libera le risorse tenute per il namespace, da chiamare quando viene eliminato
+	ForgetNamespace(namespace string)
+}
+
+// HelmDeployer implementa Deployer con le action di Helm; la action.Configuration di ogni
+// namespace viene creata da newConfiguration alla prima richiesta e poi riutilizzata, così il
+// kubeconfig e la discovery del cluster non vengono ricaricati ad ogni operazione. Ogni release
+// ha il proprio namespace, quindi la configuration viene rimossa quando la release viene
+// disinstallata o il namespace eliminato
+type HelmDeployer struct {
+	newConfiguration func(namespace string) (*action.Configuration, error)
+	mu               sync.Mutex
+	configurations   map[string]*action.Configuration
+}
+
+func (d *HelmDeployer) configuration(namespace string) (*action.Configuration, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if helm_client, ok := d.configurations[namespace]; ok {
+		return helm_client, nil
+	}
+	helm_client, err := d.newConfiguration(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if d.configurations == nil {
+		d.configurations = make(map[string]*action.Configuration)
+	}
+	d.configurations[namespace] = helm_client
+	return helm_client, nil
+}
+
+func (d *HelmDeployer) ForgetNamespace(namespace string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.configurations, namespace)
+}
+
+// NewDeployer crea un Deployer che raggiunge il cluster con il RESTClientGetter restituito
+// da restClientGetter per ogni namespace
+func NewDeployer(restClientGetter func(namespace string) genericclioptions.RESTClientGetter) *HelmDeployer {
+	return &HelmDeployer{
+		newConfiguration: func(namespace string) (*action.Configuration, error) {
+			return GetNewHelmClient(namespace, restClientGetter(namespace))
+		},
+	}
+}
+
+func (d *HelmDeployer) Install(ctx context.Context, chart *chart.Chart, values map[string]interface{}, releaseName string, namespace string) error {
+	helm_client, err := d.configuration(namespace)
+	if err != nil {
+		return err
+	}
+	return Install(ctx, chart, values, releaseName, namespace, helm_client)
+}
+
+func (d *HelmDeployer) Upgrade(ctx context.Context, chart *chart.Chart, values map[string]interface{}, releaseName string, namespace string) error {
+	helm_client, err := d.configuration(namespace)
+	if err != nil {
+		return err
+	}
+	return Upgrade(ctx, chart, values, releaseName, namespace, helm_client)
+}
+
+func (d *HelmDeployer) Uninstall(releaseName string, namespace string) error {
+	helm_client, err := d.configuration(namespace)
+	if err != nil {
+		return err
+	}
+	err = UninstallRelease(releaseName, namespace, helm_client)
+	if err != nil {
+		return err
+	}
+	// senza release nel namespace la configuration serve di nuovo solo se viene reinstallata
+	d.ForgetNamespace(namespace)
+	return nil
+}
+
+func (d *HelmDeployer) IsReleaseActive(releaseName string, namespace string) (bool, error) {
+	helm_client, err := d.configuration(namespace)
+	if err != nil {
+		return false, err
+	}
+	return IsReleaseActive(releaseName, namespace, helm_client)
+}
+
+func (d *HelmDeployer) GetHistory(releaseName string, namespace string) ([]*release.Release, error) {
+	helm_client, err := d.configuration(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return GetHistory(releaseName, helm_client)
+}
+
+func (d *HelmDeployer) GetCurrentRevision(releaseName string, namespace string) (int, error) {
+	helm_client, err := d.configuration(namespace)
+	if err != nil {
+		return 0, err
+	}
+	return GetCurrentRevision(releaseName, helm_client)
+}
+
+func (d *HelmDeployer) Rollback(releaseName string, namespace string, revision int) error {
+	helm_client, err := d.configuration(namespace)
+	if err != nil {
+		return err
+	}
+	return Rollback(releaseName, revision, helm_client)
+}
+
+func GetNewHelmClient(namespace string, restClientGetter genericclioptions.RESTClientGetter) (*action.Configuration, error) {
 	//passiamo un puntatore alla struct di actions che puo compiere Helm
 	actions := new(action.Configuration)
-	if err := actions.Init(kube.GetConfig(kube_config, "", namespace), namespace, os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
+	if err := actions.Init(restClientGetter, namespace, os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
 		log.Println("Error initializing Helm client: ", err.Error())
 		return nil, err
 	}
 	return actions, nil
 }
 
-func Install(chart *chart.Chart, values map[string]interface{}, releaseName string, namespace string, helm_client *action.Configuration) error {
+func Install(ctx context.Context, chart *chart.Chart, values map[string]interface{}, releaseName string, namespace string, helm_client *action.Configuration) error {
 	newRelease := action.NewInstall(helm_client)
 	newRelease.Namespace = namespace
 	newRelease.ReleaseName = releaseName
-	rel, err := newRelease.Run(chart, values)
+	rel, err := newRelease.RunWithContext(ctx, chart, values)
 	if err != nil {
 		log.Println("Error installing release: " + err.Error())
 		return err
@@ -38,12 +164,64 @@ func Install(chart *chart.Chart, values map[string]interface{}, releaseName stri
 	return nil
 }
 
+func Upgrade(ctx context.Context, chart *chart.Chart, values map[string]interface{}, releaseName string, namespace string, helm_client *action.Configuration) error {
+	upgrade := action.NewUpgrade(helm_client)
+	upgrade.Namespace = namespace
+	// i values caricati sostituiscono completamente quelli della revisione precedente
+	upgrade.ResetValues = true
+	rel, err := upgrade.RunWithContext(ctx, releaseName, chart, values)
+	if err != nil {
+		log.Println("Error upgrading release: " + err.Error())
+		return err
+	}
+	log.Println("Upgraded "+rel.Name+" to revision", rel.Version)
+	return nil
+}
+
+// Render esegue l'installazione in modalità dry-run senza contattare il cluster
+// e restituisce la release con i manifest generati da template.yaml
+func Render(chart *chart.Chart, values map[string]interface{}, releaseName string, namespace string) (*release.Release, error) {
+	actions := new(action.Configuration)
+	actions.Log = log.Printf
+	dryRun := action.NewInstall(actions)
+	dryRun.Namespace = namespace
+	dryRun.ReleaseName = releaseName
+	dryRun.DryRun = true
+	dryRun.ClientOnly = true
+	rel, err := dryRun.Run(chart, values)
+	if err != nil {
+		log.Println("Error rendering release: " + err.Error())
+		return nil, err
+	}
+	return rel, nil
+}
+
+// SplitManifests divide il manifest di una release nei singoli documenti yaml, nell'ordine originale
+func SplitManifests(manifest string) []string {
+	splitted := releaseutil.SplitManifests(manifest)
+	keys := make([]string, 0, len(splitted))
+	for key := range splitted {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+	manifests := make([]string, 0, len(keys))
+	for _, key := range keys {
+		manifests = append(manifests, splitted[key])
+	}
+	return manifests
+}
+
 func CreateChart(chart_name string) (*chart.Chart, error) {
 	templateFile, err := os.ReadFile("template.yaml")
 	if err != nil {
 		log.Println("Error reading template file: ", err.Error())
 		return nil, err
 	}
+	schemaFile, err := os.ReadFile("values.schema.json")
+	if err != nil {
+		log.Println("Error reading schema file: ", err.Error())
+		return nil, err
+	}
 	mychart := &chart.Chart{
 		Metadata: &chart.Metadata{
 			Name:    chart_name,
@@ -52,10 +230,195 @@ func CreateChart(chart_name string) (*chart.Chart, error) {
 		Templates: []*chart.File{
 			{Name: "template.yaml", Data: templateFile},
 		},
+		Schema: schemaFile,
 	}
 	return mychart, nil
 }
 
+// ValidateValues controlla un values.yaml caricato contro values.schema.json e restituisce
+// un errore per ogni campo non valido
+func ValidateValues(data []byte) ([]models.FieldError, error) {
+	schemaFile, err := os.ReadFile("values.schema.json")
+	if err != nil {
+		log.Println("Error reading schema file: ", err.Error())
+		return nil, err
+	}
+	values, err := chartutil.ReadValues(data)
+	if err != nil {
+		return []models.FieldError{{
+			Path:     "(root)",
+			Expected: "valid yaml",
+			Got:      "invalid yaml",
+			Message:  err.Error(),
+		}}, nil
+	}
+	valuesJson, err := json.Marshal(values)
+	if err != nil {
+		log.Println("Error converting values to json: ", err.Error())
+		return nil, err
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(schemaFile), gojsonschema.NewBytesLoader(valuesJson))
+	if err != nil {
+		log.Println("Error validating values: ", err.Error())
+		return nil, err
+	}
+	fieldErrors := make([]models.FieldError, 0)
+	for _, resultError := range result.Errors() {
+		fieldErrors = append(fieldErrors, toFieldError(resultError))
+	}
+	if len(fieldErrors) == 0 {
+		// i riferimenti tra elementi non sono esprimibili nello schema
+		fieldErrors = append(fieldErrors, validateReferences(values)...)
+		fieldErrors = append(fieldErrors, validateDependencies(values)...)
+	}
+	return fieldErrors, nil
+}
+
+// FilesVolumeName è il nome della claim e del volume dei pod in cui vengono copiati i file
+// caricati che non stanno in una ConfigMap; nessun volume dei values può usarlo
+const FilesVolumeName = "packs-files"
+
+// maxStatefulNameLen è la lunghezza massima del nome di un componente stateful: lo StatefulSet
+// <name>-statefulset etichetta i pod con controller-revision-hash=<statefulset>-<hash di 10
+// caratteri>, un valore che non può superare i 63 caratteri
+const maxStatefulNameLen = 63 - len("-statefulset") - 11
+
+// validateReferences controlla che ogni volume con persistentVolume o fromFiles faccia
+// riferimento a un elemento di persistentVolumes o di files, che i nomi in queste liste siano
+// unici, che i percorsi dei file caricati non escano dall'archivio, che restartPolicy sia
+// compatibile con il componente e che i nomi dei componenti stateful non siano troppo lunghi;
+// values deve rispettare già lo schema
+func validateReferences(values chartutil.Values) []models.FieldError {
+	fieldErrors := make([]models.FieldError, 0)
+	persistentVolumes := uniqueNames(values, "persistentVolumes", "persistent volume", &fieldErrors)
+	files := uniqueNames(values, "files", "files", &fieldErrors)
+	components, _ := values["components"].([]interface{})
+	for i, item := range components {
+		component := item.(map[string]interface{})
+		// i componenti che non ripartono sempre diventano Job, che non possono essere stateful
+		// e prenderebbero il nome del job del componente
+		if policy, ok := component["restartPolicy"].(string); ok && policy != "Always" {
+			if component["kind"] == "stateful" || component["jobs"] != nil {
+				fieldErrors = append(fieldErrors, models.FieldError{
+					Path:     fmt.Sprintf("components.%d.restartPolicy", i),
+					Expected: "Always for stateful components and components with jobs",
+					Got:      policy,
+					Message:  "restartPolicy " + policy + " can only be used by deployment components without jobs",
+				})
+			}
+		}
+		if name, _ := component["name"].(string); component["kind"] == "stateful" && len(name) > maxStatefulNameLen {
+			fieldErrors = append(fieldErrors, models.FieldError{
+				Path:     fmt.Sprintf("components.%d.name", i),
+				Expected: fmt.Sprintf("at most %d characters for stateful components", maxStatefulNameLen),
+				Got:      name,
+				Message:  fmt.Sprintf("stateful component name %s is longer than %d characters", name, maxStatefulNameLen),
+			})
+		}
+		volumes, _ := component["volumes"].([]interface{})
+		for j, v := range volumes {
+			volume := v.(map[string]interface{})
+			if volume["name"] == FilesVolumeName {
+				fieldErrors = append(fieldErrors, models.FieldError{
+					Path:     fmt.Sprintf("components.%d.volumes.%d.name", i, j),
+					Expected: "a name different from " + FilesVolumeName,
+					Got:      FilesVolumeName,
+					Message:  "volume name " + FilesVolumeName + " is reserved",
+				})
+			}
+			if name, ok := volume["persistentVolume"].(string); ok && !persistentVolumes[name] {
+				fieldErrors = append(fieldErrors, models.FieldError{
+					Path:     fmt.Sprintf("components.%d.volumes.%d.persistentVolume", i, j),
+					Expected: "a name defined in persistentVolumes",
+					Got:      name,
+					Message:  "persistent volume " + name + " is not defined",
+				})
+			}
+			if name, ok := volume["fromFiles"].(string); ok && !files[name] {
+				fieldErrors = append(fieldErrors, models.FieldError{
+					Path:     fmt.Sprintf("components.%d.volumes.%d.fromFiles", i, j),
+					Expected: "a name defined in files",
+					Got:      name,
+					Message:  "files " + name + " are not defined",
+				})
+			}
+			for _, key := range []string{"file", "directory"} {
+				p, ok := volume[key].(string)
+				if !ok || !slices.Contains(strings.Split(filepath.ToSlash(p), "/"), "..") {
+					continue
+				}
+				fieldErrors = append(fieldErrors, models.FieldError{
+					Path:     fmt.Sprintf("components.%d.volumes.%d.%s", i, j, key),
+					Expected: "a path inside the uploaded archive",
+					Got:      p,
+					Message:  "path " + p + " must not contain ..",
+				})
+			}
+		}
+	}
+	return fieldErrors
+}
+
+// uniqueNames restituisce i nomi degli elementi della lista indicata, aggiungendo un errore
+// per ogni nome ripetuto
+func uniqueNames(values chartutil.Values, list string, description string, fieldErrors *[]models.FieldError) map[string]bool {
+	names := make(map[string]bool)
+	items, _ := values[list].([]interface{})
+	for i, item := range items {
+		name := fmt.Sprint(item.(map[string]interface{})["name"])
+		if names[name] {
+			*fieldErrors = append(*fieldErrors, models.FieldError{
+				Path:     fmt.Sprintf("%s.%d.name", list, i),
+				Expected: "unique name",
+				Got:      name,
+				Message:  description + " " + name + " is defined more than once",
+			})
+		}
+		names[name] = true
+	}
+	return names
+}
+
+func toFieldError(resultError gojsonschema.ResultError) models.FieldError {
+	details := resultError.Details()
+	fieldError := models.FieldError{
+		Path:    resultError.Field(),
+		Message: resultError.Description(),
+	}
+	switch resultError.Type() {
+	case "additional_property_not_allowed":
+		// il percorso punta all'oggetto, aggiungiamo la proprietà sconosciuta (es. hostport invece di hostPort)
+		fieldError.Path = resultError.Field() + "." + fmt.Sprint(details["property"])
+		fieldError.Expected = "no additional properties"
+		fieldError.Got = fmt.Sprint(details["property"])
+	case "number_any_of", "number_one_of":
+		// il dettaglio è negli errori dei singoli schemi alternativi, riportati subito dopo
+		fieldError.Expected = "one of the allowed forms"
+		fieldError.Got = "none matched"
+	case "required":
+		fieldError.Expected = fmt.Sprint(details["property"])
+		fieldError.Got = "missing"
+	default:
+		if expected, ok := details["expected"]; ok {
+			fieldError.Expected = fmt.Sprint(expected)
+		} else if allowed, ok := details["allowed"]; ok {
+			fieldError.Expected = fmt.Sprint(allowed)
+		} else if min, ok := details["min"]; ok {
+			fieldError.Expected = ">= " + fmt.Sprint(min)
+		} else if max, ok := details["max"]; ok {
+			fieldError.Expected = "<= " + fmt.Sprint(max)
+		} else if pattern, ok := details["pattern"]; ok {
+			fieldError.Expected = "match " + fmt.Sprint(pattern)
+		}
+		if given, ok := details["given"]; ok {
+			fieldError.Got = fmt.Sprint(given)
+		} else {
+			fieldError.Got = fmt.Sprint(resultError.Value())
+		}
+	}
+	return fieldError
+}
+
 func GetReleaseList(helm_client *action.Configuration) ([]*release.Release, error) {
 	list := action.NewList(helm_client)
 	rels, err := list.Run()
@@ -82,9 +445,9 @@ func IsReleaseActive(rel_jwt string, namespace string, helm_client *action.Confi
 	return false, nil
 }
 
-func GetValues(jwt string) (map[string]interface{}, error) {
+func GetValues(valuesPath string) (map[string]interface{}, error) {
 	//leggi values.yaml da file usando le chartutils ufficiali
-	values, err := chartutil.ReadValuesFile("/shared/uploads/" + jwt + "/values.yaml")
+	values, err := chartutil.ReadValuesFile(valuesPath)
 	if err != nil {
 		log.Println("Error reading values file: ", err.Error())
 		return nil, err
@@ -102,3 +465,36 @@ func UninstallRelease(rel_jwt string, namespace string, helm_client *action.Conf
 	log.Println("Uninstalled release", rel_jwt)
 	return nil
 }
+
+func GetHistory(rel_jwt string, helm_client *action.Configuration) ([]*release.Release, error) {
+	history := action.NewHistory(helm_client)
+	history.Max = 256
+	rels, err := history.Run(rel_jwt)
+	if err != nil {
+		log.Println("Error getting release history: ", err.Error())
+		return nil, err
+	}
+	return rels, nil
+}
+
+func GetCurrentRevision(rel_jwt string, helm_client *action.Configuration) (int, error) {
+	status := action.NewStatus(helm_client)
+	rel, err := status.Run(rel_jwt)
+	if err != nil {
+		log.Println("Error getting release status: ", err.Error())
+		return 0, err
+	}
+	return rel.Version, nil
+}
+
+func Rollback(rel_jwt string, revision int, helm_client *action.Configuration) error {
+	rollback := action.NewRollback(helm_client)
+	rollback.Version = revision
+	err := rollback.Run(rel_jwt)
+	if err != nil {
+		log.Println("Error rolling back release: ", err.Error())
+		return err
+	}
+	log.Println("Rolled back release", rel_jwt, "to revision", revision)
+	return nil
+}
@@ -0,0 +1,147 @@
+package helmInterface
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// CreateChart legge template.yaml e values.schema.json dalla cartella del modulo
+	err := os.Chdir("..")
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestDeployerForgetsNamespaces(t *testing.T) {
+	values := map[string]interface{}{"components": []interface{}{
+		map[string]interface{}{"name": "web", "image": "nginx", "active": true},
+	}}
+	tests := []struct {
+		name string
+		run  func(d *HelmDeployer, namespace string) error
+	}{
+		{
+			name: "uninstall",
+			run: func(d *HelmDeployer, namespace string) error {
+				chart, err := CreateChart("demo")
+				if err != nil {
+					return err
+				}
+				err = d.Install(context.Background(), chart, values, "demo", namespace)
+				if err != nil {
+					return err
+				}
+				return d.Uninstall("demo", namespace)
+			},
+		},
+		{
+			name: "namespace deleted",
+			run: func(d *HelmDeployer, namespace string) error {
+				_, err := d.IsReleaseActive("demo", namespace)
+				if err != nil {
+					return err
+				}
+				d.ForgetNamespace(namespace)
+				return nil
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewMemoryDeployer()
+			// la configuration di un altro namespace non deve essere toccata
+			_, err := d.IsReleaseActive("other", "packs-other")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = test.run(d, "packs-demo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := d.configurations["packs-demo"]; ok {
+				t.Errorf("configuration of packs-demo still cached")
+			}
+			if _, ok := d.configurations["packs-other"]; !ok {
+				t.Errorf("configuration of packs-other was removed")
+			}
+		})
+	}
+}
+
+func TestValidateStatefulNameLength(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      string
+		length    int
+		wantError bool
+	}{
+		{name: "stateful at the limit", kind: "stateful", length: 40},
+		{name: "stateful over the limit", kind: "stateful", length: 41, wantError: true},
+		{name: "deployment over the stateful limit", kind: "deployment", length: 41},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := "components:\n- name: " + strings.Repeat("a", test.length) + "\n  image: postgres\n  active: true\n  kind: " + test.kind + "\n"
+			fieldErrors, err := ValidateValues([]byte(values))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantError != (len(fieldErrors) == 1 && fieldErrors[0].Path == "components.0.name") {
+				t.Errorf("ValidateValues() = %v, want name error %v", fieldErrors, test.wantError)
+			}
+		})
+	}
+}
+
+func TestRenderHealthcheckProbes(t *testing.T) {
+	tests := []struct {
+		name        string
+		healthcheck map[string]interface{}
+		wantProbes  []string
+	}{
+		{
+			name:        "readiness only",
+			healthcheck: map[string]interface{}{"tcp": map[string]interface{}{"port": 80}, "startPeriod": "30s"},
+			wantProbes:  []string{"readinessProbe"},
+		},
+		{
+			name:        "restart",
+			healthcheck: map[string]interface{}{"tcp": map[string]interface{}{"port": 80}, "restart": true},
+			wantProbes:  []string{"readinessProbe", "livenessProbe"},
+		},
+		{
+			name:        "restart after start period",
+			healthcheck: map[string]interface{}{"tcp": map[string]interface{}{"port": 80}, "restart": true, "startPeriod": "30s"},
+			wantProbes:  []string{"readinessProbe", "livenessProbe", "startupProbe"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chart, err := CreateChart("demo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := map[string]interface{}{"components": []interface{}{
+				map[string]interface{}{"name": "web", "image": "nginx", "active": true, "healthcheck": test.healthcheck},
+			}}
+			rendered, err := Render(chart, values, "demo", "packs-demo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, probe := range []string{"readinessProbe", "livenessProbe", "startupProbe"} {
+				want := slices.Contains(test.wantProbes, probe)
+				if strings.Contains(rendered.Manifest, probe+":") != want {
+					t.Errorf("%s rendered = %v, want %v", probe, !want, want)
+				}
+			}
+		})
+	}
+}
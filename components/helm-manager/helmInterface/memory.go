@@ -0,0 +1,71 @@
+package helmInterface
+
+import (
+	"context"
+	"io"
+	"log"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	helmtime "helm.sh/helm/v3/pkg/time"
+)
+
+// NewMemoryDeployer crea un Deployer che salva le release in memoria (driver.Memory) e non
+// applica i manifest a nessun cluster; le action eseguite sono le stesse di NewDeployer
+func NewMemoryDeployer() *HelmDeployer {
+	return &HelmDeployer{
+		newConfiguration: func(namespace string) (*action.Configuration, error) {
+			memory := driver.NewMemory()
+			memory.SetNamespace(namespace)
+			return &action.Configuration{
+				Releases:     storage.Init(memory),
+				KubeClient:   &kubefake.PrintingKubeClient{Out: io.Discard},
+				Capabilities: chartutil.DefaultCapabilities,
+				Log:          log.Printf,
+			}, nil
+		},
+	}
+}
+
+// FailingDeployer è un Deployer in memoria in cui ogni installazione fallisce con Err
+type FailingDeployer struct {
+	*HelmDeployer
+	Err error
+}
+
+// NewFailingMemoryDeployer crea un Deployer come NewMemoryDeployer in cui Install fallisce con err
+func NewFailingMemoryDeployer(err error) *FailingDeployer {
+	return &FailingDeployer{HelmDeployer: NewMemoryDeployer(), Err: err}
+}
+
+// Install registra la release con lo stato failed, come fa Helm quando il cluster rifiuta i
+// manifest, e restituisce Err
+func (d *FailingDeployer) Install(ctx context.Context, chart *chart.Chart, values map[string]interface{}, releaseName string, namespace string) error {
+	helm_client, err := d.configuration(namespace)
+	if err != nil {
+		return err
+	}
+	now := helmtime.Now()
+	err = helm_client.Releases.Create(&release.Release{
+		Name:      releaseName,
+		Namespace: namespace,
+		Version:   1,
+		Chart:     chart,
+		Config:    values,
+		Info: &release.Info{
+			FirstDeployed: now,
+			LastDeployed:  now,
+			Status:        release.StatusFailed,
+			Description:   "Release \"" + releaseName + "\" failed: " + d.Err.Error(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return d.Err
+}
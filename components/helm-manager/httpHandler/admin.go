@@ -0,0 +1,113 @@
+package httpHandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"helm3-manager/models"
+	"helm3-manager/relHandler"
+	"net/http"
+	"strconv"
+)
+
+// UserRoleHandler riceve una post con i campi cf e role e assegna il ruolo all'utente
+func (h *Handlers) UserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		err := h.releases.SetUserRole(r.Context(), r.FormValue("cf"), r.FormValue("role"))
+		if err != nil {
+			writeError(w, r, err, "Error in setting user role")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// CourseMemberHandler riceve una post con i campi course, cf e member: con member=false
+// l'utente viene rimosso dal corso, altrimenti viene aggiunto
+func (h *Handlers) CourseMemberHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		member := r.FormValue("member") != "false"
+		err := h.releases.SetCourseMember(r.Context(), r.FormValue("course"), r.FormValue("cf"), member)
+		if err != nil {
+			writeError(w, r, err, "Error in updating course")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// CourseMembersHandler restituisce i cf degli utenti del corso indicato in query string
+func (h *Handlers) CourseMembersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		members, err := h.releases.GetCourseMembers(r.Context(), userFromRequest(r), r.URL.Query().Get("course"))
+		if err != nil {
+			writeError(w, r, err, "Error in getting course members")
+			return
+		}
+		json_bytes, err := json.Marshal(members)
+		if err != nil {
+			writeError(w, r, err, "Error in getting course members")
+			return
+		}
+		writeMessage(w, string(json_bytes))
+	}
+}
+
+// QuotaHandler restituisce la quota in vigore e l'utilizzo dell'utente, o dell'utente indicato
+// dal parametro owner per docenti e amministratori
+func (h *Handlers) QuotaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		json_quota, err := h.releases.GetQuota(r.Context(), userFromRequest(r), r.URL.Query().Get("owner"))
+		if err != nil {
+			writeError(w, r, err, "Error in getting quota")
+			return
+		}
+		writeMessage(w, json_quota)
+	}
+}
+
+// AdminQuotaHandler legge (GET) o sostituisce (POST) la quota dell'utente cf o del corso course;
+// la post contiene i campi maxReleases, maxActiveReleases, maxReplicas, maxCpu e maxMemory,
+// un campo vuoto toglie il limite che viene ereditato dai corsi o dalla configurazione
+func (h *Handlers) AdminQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		json_quota, err := h.releases.GetStoredQuota(r.Context(), r.URL.Query().Get("cf"), r.URL.Query().Get("course"))
+		if err != nil {
+			writeError(w, r, err, "Error in getting quota")
+			return
+		}
+		writeMessage(w, json_quota)
+	case "POST":
+		quota, err := quotaFromForm(r)
+		if err == nil {
+			err = h.releases.SetQuota(r.Context(), r.FormValue("cf"), r.FormValue("course"), quota)
+		}
+		if err != nil {
+			writeError(w, r, err, "Error in setting quota")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func quotaFromForm(r *http.Request) (*models.Quota, error) {
+	quota := &models.Quota{
+		MaxCPU:    r.FormValue("maxCpu"),
+		MaxMemory: r.FormValue("maxMemory"),
+	}
+	for field, value := range map[string]*int{
+		"maxReleases":       &quota.MaxReleases,
+		"maxActiveReleases": &quota.MaxActiveReleases,
+		"maxReplicas":       &quota.MaxReplicas,
+	} {
+		if r.FormValue(field) == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(r.FormValue(field))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be an integer", relHandler.ErrInvalidRequest, field)
+		}
+		*value = parsed
+	}
+	return quota, nil
+}
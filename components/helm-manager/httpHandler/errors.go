@@ -0,0 +1,122 @@
+package httpHandler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"helm3-manager/models"
+	"helm3-manager/relHandler"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// un request id ricevuto dal client viene riusato solo se è breve e non contiene caratteri di controllo
+var validRequestID = regexp.MustCompile(`^[-_.a-zA-Z0-9]{1,64}$`)
+
+type requestIDKey struct{}
+
+// codice HTTP e codice applicativo degli errori di relHandler, gli errori non presenti
+// vengono restituiti come 500 senza riportare il messaggio originale all'utente
+var errorStatuses = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{relHandler.ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{relHandler.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{relHandler.ErrReleaseNotFound, http.StatusNotFound, "release_not_found"},
+	{relHandler.ErrReleaseActive, http.StatusConflict, "release_active"},
+	{relHandler.ErrReleaseInactive, http.StatusConflict, "release_not_active"},
+	{relHandler.ErrQuotaExceeded, http.StatusTooManyRequests, "quota_exceeded"},
+	{relHandler.ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	{relHandler.ErrInvalidValues, http.StatusUnprocessableEntity, "invalid_values"},
+	{relHandler.ErrTokensDisabled, http.StatusNotImplemented, "tokens_disabled"},
+}
+
+// RequestIDHandler assegna ad ogni richiesta un id, ripreso dall'header X-Request-ID se presente,
+// che viene restituito nella risposta e negli errori per ritrovare la richiesta nei log
+func RequestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		log.Println("Could not generate request id", err)
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// writeError è l'unico punto in cui viene scritta la risposta di una richiesta fallita;
+// message viene mostrato all'utente solo per gli errori interni, che non devono esporre dettagli
+func writeError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	requestID := requestIDFromContext(r.Context())
+	apiError := models.APIError{
+		Code:      "internal_error",
+		Message:   message,
+		RequestID: requestID,
+	}
+	status := http.StatusInternalServerError
+	var validationError *models.ValidationError
+	if errors.As(err, &validationError) {
+		status = http.StatusUnprocessableEntity
+		apiError.Code = "invalid_values"
+		apiError.Message = "Invalid values file"
+		apiError.Details = validationError.Errors
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+		apiError.Code = "timeout"
+		apiError.Message = "Request timed out"
+	} else {
+		for _, errorStatus := range errorStatuses {
+			if errors.Is(err, errorStatus.err) {
+				status = errorStatus.status
+				apiError.Code = errorStatus.code
+				apiError.Message = err.Error()
+				break
+			}
+		}
+	}
+	log.Println("Request", requestID, "failed with status", status, ":", err)
+	writeJSON(w, status, apiError)
+}
+
+// writeMessage risponde con il json prodotto da relHandler racchiuso in un models.Message
+func writeMessage(w http.ResponseWriter, message string) {
+	writeJSON(w, http.StatusOK, models.Message{Type: "message", Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	json_bytes, err := json.Marshal(body)
+	if err != nil {
+		log.Println("Could not marshal json", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(json_bytes)
+	if err != nil {
+		log.Println("Could not write response", err)
+	}
+}
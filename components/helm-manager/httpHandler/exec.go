@@ -0,0 +1,163 @@
+package httpHandler
+
+import (
+	"helm3-manager/k8sInterface"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// l'autenticazione avviene tramite l'header Authorization, che un sito esterno non può impostare
+// su una connessione WebSocket, quindi non serve controllare l'origine
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// messaggi scambiati sulla WebSocket del terminale:
+// dal client {"type":"stdin","data":"..."} e {"type":"resize","cols":80,"rows":24},
+// dal server {"type":"stdout","data":"..."}, {"type":"stderr","data":"..."} e {"type":"exit","data":"<errore>"}
+type terminalMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+}
+
+// ExecHandler apre una shell interattiva in un pod della release collegandola ad una WebSocket.
+// Il pod arriva nell'header podName, container e command (separato da spazi) in query string
+func (h *Handlers) ExecHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		return
+	}
+	rel := releaseFromRequest(r)
+	podName := r.Header.Get("podName")
+	err := h.releases.CheckReleaseActive(r.Context(), rel)
+	if err != nil {
+		writeError(w, r, err, "Error in opening terminal")
+		return
+	}
+	command := []string{"/bin/sh"}
+	if value := r.URL.Query().Get("command"); value != "" {
+		command = strings.Fields(value)
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Could not upgrade connection", err)
+		return
+	}
+	defer conn.Close()
+
+	session := newTerminalSession(conn)
+	defer session.close()
+	go session.readLoop()
+
+	err = h.releases.ExecInReleasePod(r.Context(), rel, podName, k8sInterface.ExecOptions{
+		Container: r.URL.Query().Get("container"),
+		Command:   command,
+		TTY:       true,
+		Stdin:     session.stdin,
+		Stdout:    session.writer("stdout"),
+		Stderr:    session.writer("stderr"),
+		SizeQueue: session,
+	})
+	exitMessage := ""
+	if err != nil {
+		log.Println("Terminal session ended with error: ", err.Error())
+		exitMessage = err.Error()
+	}
+	session.send(terminalMessage{Type: "exit", Data: exitMessage})
+}
+
+// terminalSession collega la WebSocket agli stream dell'exec
+type terminalSession struct {
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+	stdin     *io.PipeReader
+	stdinPipe *io.PipeWriter
+	resize    chan remotecommand.TerminalSize
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newTerminalSession(conn *websocket.Conn) *terminalSession {
+	stdin, stdinPipe := io.Pipe()
+	return &terminalSession{
+		conn:      conn,
+		stdin:     stdin,
+		stdinPipe: stdinPipe,
+		resize:    make(chan remotecommand.TerminalSize, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+// readLoop legge i messaggi del client finché la WebSocket resta aperta
+func (t *terminalSession) readLoop() {
+	defer t.stdinPipe.Close()
+	for {
+		var message terminalMessage
+		err := t.conn.ReadJSON(&message)
+		if err != nil {
+			return
+		}
+		switch message.Type {
+		case "stdin":
+			_, err = t.stdinPipe.Write([]byte(message.Data))
+			if err != nil {
+				return
+			}
+		case "resize":
+			select {
+			case t.resize <- remotecommand.TerminalSize{Width: message.Cols, Height: message.Rows}:
+			case <-t.done:
+				return
+			}
+		}
+	}
+}
+
+// Next implementa remotecommand.TerminalSizeQueue, nil segnala la fine della sessione
+func (t *terminalSession) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-t.resize:
+		return &size
+	case <-t.done:
+		return nil
+	}
+}
+
+func (t *terminalSession) send(message terminalMessage) error {
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+	return t.conn.WriteJSON(message)
+}
+
+func (t *terminalSession) writer(streamType string) io.Writer {
+	return terminalWriter{session: t, streamType: streamType}
+}
+
+func (t *terminalSession) close() {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.stdin.Close()
+	})
+}
+
+type terminalWriter struct {
+	session    *terminalSession
+	streamType string
+}
+
+func (w terminalWriter) Write(p []byte) (int, error) {
+	err := w.session.send(terminalMessage{Type: w.streamType, Data: string(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,28 @@
+package httpHandler
+
+import (
+	"log"
+	"net/http"
+)
+
+// header con cui gli init container delle release presentano il token per scaricare i file
+const filesTokenHeader = "X-Files-Token"
+
+// FilesHandler restituisce come archivio tar i file caricati della release indicata in query
+// string; non passa da AuthHandler perché viene chiamato dai pod, autenticati dal token della release
+func (h *Handlers) FilesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		jwt := r.URL.Query().Get("release")
+		err := h.releases.CheckFilesToken(r.Context(), jwt, r.Header.Get(filesTokenHeader))
+		if err != nil {
+			writeError(w, r, err, "Error in getting files")
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-tar")
+		// dopo l'inizio della risposta un errore può solo interrompere l'archivio
+		err = h.releases.WriteReleaseFiles(jwt, w)
+		if err != nil {
+			log.Println("Could not send files of release", jwt, err)
+		}
+	}
+}
@@ -1,14 +1,24 @@
 package httpHandler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"helm3-manager/models"
-	"helm3-manager/redisInterface"
 	"helm3-manager/relHandler"
 	"log"
 	"net/http"
+	"time"
 )
 
-var Message = new(models.Message)
+// Handlers contiene gli handler HTTP di helm-manager, che operano sulle release tramite releases
+type Handlers struct {
+	releases *relHandler.Service
+}
+
+func NewHandlers(releases *relHandler.Service) *Handlers {
+	return &Handlers{releases: releases}
+}
 
 func ComposeMiddlewares(handler http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
 	for _, middleware := range middlewares {
@@ -21,183 +31,293 @@ func CorsHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // permette a tutti di fare richieste, da cambiare in produzione
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, referredChart")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, referredChart, revision, podName, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		// la preflight non contiene il token, va conclusa prima dell'autenticazione
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// middleware che verifica la presenza di un token jwt e la sua validità
-func JwtTokenVerificationHandler(writer http.ResponseWriter, request *http.Request) {
-	token := request.Header.Get("Authorization")
-	//fmt.Println("New request with auth Token: ", token)
-	check, err := redisInterface.CheckPresence(token)
-	if err != nil {
-		http.Error(writer, Message.JsonError("Error in token verification"), http.StatusInternalServerError)
-		log.Println("Error in token verification: ", err.Error())
-		return
-	}
-	if !check {
-		http.Error(writer, Message.JsonError("Unauthorized request"), http.StatusUnauthorized)
-		log.Println("Unauthorized request from", request.RemoteAddr)
-		return
+// TimeoutHandler cancella il context della richiesta dopo timeout, interrompendo le chiamate a
+// Redis, Helm e Kubernetes ancora in corso; non va usato per log in streaming e terminale
+func TimeoutHandler(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
 	}
 }
 
-// questa funzione rivece una post con un campo name, un file yaml ed un file zip, il file zip non è obbligatorio e se presente deve essere estratto in una cartella
-// con nome di un token jwt appena generato
-func UploadHandler(next http.Handler) http.Handler {
+type userKey struct{}
+
+// AuthHandler risolve l'utente dal token dell'header Authorization e lo salva nel context della
+// richiesta; se il token non corrisponde a una sessione la richiesta viene rifiutata con 401
+// senza raggiungere l'handler
+func (h *Handlers) AuthHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		check, err := relHandler.CheckNumberOfReleasePerToken(r.Header.Get("Authorization"))
+		user, err := h.releases.Authenticate(r.Context(), r.Header.Get("Authorization"))
 		if err != nil {
-			http.Error(w, Message.JsonError(err), http.StatusInternalServerError)
-			log.Println("Error in file upload: ", err.Error())
-			return
-		}
-		if !check {
-			http.Error(w, Message.JsonError("Forbidden request"), http.StatusForbidden)
-			log.Println("Forbidden request from", r.RemoteAddr, "for exceeding the number of releases")
+			if errors.Is(err, relHandler.ErrUnauthorized) {
+				log.Println("Unauthorized request from", r.RemoteAddr)
+			}
+			writeError(w, r, err, "Error in token verification")
 			return
 		}
-		if r.Method == "POST" {
-			jwt := relHandler.MakeUnicJwt()
-			relHandler.MakeReleaseDirIfNotExist(jwt)
-			err := relHandler.ZipHandler(r, jwt)
-			err2 := relHandler.YamlHandler(r, jwt)
-			err3 := relHandler.SaveToRedis(jwt, r.FormValue("name"), r.Header.Get("Authorization"))
+		ctx := context.WithValue(r.Context(), userKey{}, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// userFromRequest restituisce l'utente salvato da AuthHandler, gli handler che lo usano
+// devono essere sempre composti con AuthHandler
+func userFromRequest(r *http.Request) *models.User {
+	return r.Context().Value(userKey{}).(*models.User)
+}
 
-			if err != nil || err2 != nil || err3 != nil {
-				http.Error(w, Message.JsonError(err, err2, err3), http.StatusInternalServerError)
-				log.Println("Error in file upload: ", err.Error())
-				relHandler.RemoveFolderDirectoryIfExist(jwt)
+type releaseKey struct{}
+
+// ReleaseAccess carica la release indicata dall'header referredChart e la passa all'handler
+// solo se l'utente autenticato può eseguire action; va composto all'interno di AuthHandler
+func (h *Handlers) ReleaseAccess(action relHandler.Action) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rel, err := h.releases.AuthorizeRelease(r.Context(), userFromRequest(r), r.Header.Get("referredChart"), action)
+			if err != nil {
+				writeError(w, r, err, "Error in getting release")
 				return
 			}
-			w.WriteHeader(http.StatusOK)
+			ctx := context.WithValue(r.Context(), releaseKey{}, rel)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-		}
-	})
+// releaseFromRequest restituisce la release autorizzata da ReleaseAccess
+func releaseFromRequest(r *http.Request) *models.Release {
+	return r.Context().Value(releaseKey{}).(*models.Release)
 }
 
-func ListHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			json_rels, err := relHandler.GetReleasesList(w, r.Header.Get("Authorization"))
-			if err != nil {
-				http.Error(w, Message.JsonError("Error in getting list"), http.StatusInternalServerError)
-				log.Println("Error in getting list: ", err.Error())
-				return
+// RequireRole rifiuta con 403 le richieste degli utenti che non hanno uno dei ruoli indicati
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := userFromRequest(r)
+			for _, role := range roles {
+				if user.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
 			}
-			w.Header().Set("Content-Type", "application/json")
-			_, err = w.Write([]byte(Message.JsonMessage(json_rels)))
-			if err != nil {
-				log.Println("Could not write response", err)
+			log.Println("User", user.Cf, "with role", user.Role, "cannot access", r.URL.Path)
+			writeError(w, r, relHandler.ErrForbidden, "Forbidden request")
+		})
+	}
+}
+
+// questa funzione rivece una post con un campo name, un file yaml ed un archivio (zip, tar, tar.gz o tar.zst), l'archivio non è obbligatorio e se presente deve essere estratto in una cartella
+// con nome l'id generato per la release a partire dal campo name. Al posto del file yaml si può
+// caricare un docker-compose.yml nel campo composeFile, che viene convertito nei values
+func (h *Handlers) UploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		// controllo anticipato per non leggere il caricamento, SaveToRedis lo ripete con il lock
+		err := h.releases.CheckReleaseQuota(r.Context(), userFromRequest(r))
+		if err != nil {
+			writeError(w, r, err, "Error in file upload")
+			return
+		}
+		err = h.releases.ParseUploadForm(w, r)
+		if err != nil {
+			writeError(w, r, err, "Error in file upload")
+			return
+		}
+		compose, err := h.releases.IsComposeUpload(r)
+		if err != nil {
+			writeError(w, r, err, "Error in file upload")
+			return
+		}
+		jwt, err := h.releases.NewReleaseID(r.Context(), r.FormValue("name"))
+		if err != nil {
+			writeError(w, r, err, "Error in file upload")
+			return
+		}
+		h.releases.MakeReleaseDirIfNotExist(jwt)
+		warnings := make([]models.Warning, 0)
+		if compose {
+			// env_file e bind mount del docker-compose.yml sono tra i file dell'archivio
+			err = h.releases.ArchiveHandler(r, jwt)
+			if err == nil {
+				warnings, err = h.releases.ComposeHandler(r, jwt)
+			}
+		} else {
+			// il values.yaml viene validato prima di accettare il resto del caricamento
+			err = h.releases.YamlHandler(r, jwt)
+			if err == nil {
+				err = h.releases.ArchiveHandler(r, jwt)
 			}
 		}
+		if err == nil {
+			err = h.releases.SaveToRedis(r.Context(), jwt, r.FormValue("name"), userFromRequest(r))
+		}
+		if err != nil {
+			h.releases.RemoveFolderDirectoryIfExist(jwt)
+			h.releases.FreeReleaseID(r.Context(), jwt)
+			writeError(w, r, err, "Error in file upload")
+			return
+		}
+		// gli avvisi riportano le parti del docker-compose.yml ignorate dalla conversione
+		json_bytes, err := json.Marshal(map[string]interface{}{
+			"jwt":      jwt,
+			"warnings": warnings,
+		})
+		if err != nil {
+			writeError(w, r, err, "Error in file upload")
+			return
+		}
+		writeMessage(w, string(json_bytes))
+	}
+}
+
+func (h *Handlers) ListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		json_rels, err := h.releases.GetReleasesList(r.Context(), userFromRequest(r), r.URL.Query().Get("owner"))
+		if err != nil {
+			writeError(w, r, err, "Error in getting list")
+			return
+		}
+		writeMessage(w, json_rels)
+	}
 
-	})
 }
 
-func InstallHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			err := relHandler.InstallRelease(w, r.Header.Get("Authorization"), r.Header.Get("referredChart"))
-			if err != nil {
-				log.Println("Error in installing release")
-				http.Error(w, Message.JsonError("Error in installing release"), http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(http.StatusOK)
+func (h *Handlers) InstallHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		err := h.releases.InstallRelease(r.Context(), releaseFromRequest(r))
+		if err != nil {
+			writeError(w, r, err, "Error in installing release")
+			return
 		}
+		w.WriteHeader(http.StatusOK)
+	}
 
-	})
 }
 
-func DeleteHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			err := relHandler.DeleteRelease(r.Header.Get("Authorization"), r.Header.Get("referredChart"))
-			if err != nil {
-				http.Error(w, Message.JsonError("Error in deleting release"), http.StatusInternalServerError)
-				log.Println("Error in deleting release: ", err.Error())
-				return
-			}
-			w.WriteHeader(http.StatusOK)
+// riceve una post con un nuovo file yaml ed un archivio opzionale per una release esistente
+// e aggiorna la release sul posto se è attiva
+func (h *Handlers) UpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		err := h.releases.ParseUploadForm(w, r)
+		if err == nil {
+			err = h.releases.UpgradeRelease(r.Context(), r, releaseFromRequest(r))
+		}
+		if err != nil {
+			writeError(w, r, err, "Error in upgrading release")
+			return
 		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
 
-	})
+func (h *Handlers) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		history, err := h.releases.GetReleaseHistory(r.Context(), releaseFromRequest(r))
+		if err != nil {
+			writeError(w, r, err, "Error in getting history")
+			return
+		}
+		writeMessage(w, history)
+	}
 }
-func StopHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			err := relHandler.StopRelease(r.Header.Get("Authorization"), r.Header.Get("referredChart"))
-			if err != nil {
-				http.Error(w, Message.JsonError("Error in stopping release"), http.StatusInternalServerError)
-				log.Println("Error in stopping release: ", err.Error())
-				return
-			}
-			w.WriteHeader(http.StatusOK)
+
+func (h *Handlers) RollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		err := h.releases.RollbackRelease(r.Context(), releaseFromRequest(r), r.Header.Get("revision"))
+		if err != nil {
+			writeError(w, r, err, "Error in rolling back release")
+			return
 		}
-	})
+		w.WriteHeader(http.StatusOK)
+	}
 }
 
-func DetailsHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			details, err := relHandler.GetReleaseDetails(r.Header.Get("Authorization"), r.Header.Get("referredChart"))
-			if err != nil {
-				http.Error(w, Message.JsonError("Error in getting details"), http.StatusInternalServerError)
-				log.Println("Error in getting details: ", err.Error())
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			_, err = w.Write([]byte(Message.JsonMessage(details)))
-			if err != nil {
-				log.Println("Could not write response", err)
-			}
+// restituisce i manifest che verrebbero installati, gli errori del template vengono riportati all'utente
+func (h *Handlers) RenderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		rendered, err := h.releases.RenderRelease(r.Context(), releaseFromRequest(r))
+		if err != nil {
+			writeError(w, r, err, "Error in rendering release")
+			return
 		}
-	})
+		writeMessage(w, rendered)
+	}
 }
-func LogsHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			logs, err := relHandler.GetReleaseLogs(r.Header.Get("Authorization"), r.Header.Get("referredChart"), r.Header.Get("podName"))
-			if err != nil {
-				http.Error(w, Message.JsonError("Error in getting logs"), http.StatusInternalServerError)
-				log.Println("Error in getting logs: ", err.Error())
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			_, err = w.Write([]byte(Message.JsonMessage(logs)))
-			if err != nil {
-				log.Println("Could not write response", err)
-			}
+
+func (h *Handlers) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		err := h.releases.DeleteRelease(r.Context(), releaseFromRequest(r))
+		if err != nil {
+			writeError(w, r, err, "Error in deleting release")
+			return
 		}
-	})
+		w.WriteHeader(http.StatusOK)
+	}
+
+}
+func (h *Handlers) StopHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		err := h.releases.StopRelease(r.Context(), releaseFromRequest(r))
+		if err != nil {
+			writeError(w, r, err, "Error in stopping release")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
 }
 
-func DeliveredListHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			err := relHandler.DeliverRelease(r.Header.Get("Authorization"), r.Header.Get("referredChart"))
-			if err != nil {
-				http.Error(w, Message.JsonError("Error in delivering release"), http.StatusInternalServerError)
-				log.Println("Error in delivering release: ", err.Error())
-				return
-			}
-			w.WriteHeader(http.StatusOK)
+func (h *Handlers) DetailsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		details, err := h.releases.GetReleaseDetails(r.Context(), releaseFromRequest(r))
+		if err != nil {
+			writeError(w, r, err, "Error in getting details")
+			return
 		}
-	})
+		writeMessage(w, details)
+	}
+}
+func (h *Handlers) LogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		logs, err := h.releases.GetReleaseLogs(r.Context(), releaseFromRequest(r), r.Header.Get("podName"))
+		if err != nil {
+			writeError(w, r, err, "Error in getting logs")
+			return
+		}
+		writeMessage(w, logs)
+	}
 }
 
-func UndeliverHandler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			err := relHandler.UndeliverRelease(r.Header.Get("Authorization"), r.Header.Get("referredChart"))
-			if err != nil {
-				http.Error(w, Message.JsonError("Error in undelivering release"), http.StatusInternalServerError)
-				log.Println("Error in undelivering release: ", err.Error())
-				return
-			}
-			w.WriteHeader(http.StatusOK)
+func (h *Handlers) DeliveredListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		err := h.releases.DeliverRelease(r.Context(), releaseFromRequest(r))
+		if err != nil {
+			writeError(w, r, err, "Error in delivering release")
+			return
 		}
-	})
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (h *Handlers) UndeliverHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		err := h.releases.UndeliverRelease(r.Context(), releaseFromRequest(r))
+		if err != nil {
+			writeError(w, r, err, "Error in undelivering release")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
 }
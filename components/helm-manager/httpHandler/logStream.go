@@ -0,0 +1,131 @@
+package httpHandler
+
+import (
+	"bufio"
+	"fmt"
+	"helm3-manager/k8sInterface"
+	"helm3-manager/relHandler"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// intervallo dei commenti inviati per tenere aperta la connessione quando il pod non scrive log
+const logStreamHeartbeat = 15 * time.Second
+
+// LogStreamHandler invia i log di un pod come Server-Sent Events, una riga per evento.
+// Le opzioni arrivano come query string: container, tailLines, sinceSeconds, previous, follow
+func (h *Handlers) LogStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, fmt.Errorf("response writer does not support flushing"), "Streaming not supported")
+		return
+	}
+	options, err := parseLogOptions(r)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("%w: %s", relHandler.ErrInvalidRequest, err.Error()), "Invalid log options")
+		return
+	}
+	podName := r.Header.Get("podName")
+	if podName == "" {
+		podName = r.URL.Query().Get("podName")
+	}
+	stream, err := h.releases.StreamReleaseLogs(r.Context(), releaseFromRequest(r), podName, options)
+	if err != nil {
+		writeError(w, r, err, "Error in streaming logs")
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stream)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-r.Context().Done():
+				return
+			}
+		}
+		scanErr <- scanner.Err()
+	}()
+
+	heartbeat := time.NewTicker(logStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case line, ok := <-lines:
+			if !ok {
+				if err := <-scanErr; err != nil {
+					log.Println("Error reading log stream: ", err.Error())
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", strings.ReplaceAll(err.Error(), "\n", " "))
+				}
+				fmt.Fprint(w, "event: end\ndata: \n\n")
+				flusher.Flush()
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", strings.TrimRight(line, "\r"))
+			flusher.Flush()
+		}
+	}
+}
+
+func parseLogOptions(r *http.Request) (k8sInterface.LogOptions, error) {
+	query := r.URL.Query()
+	options := k8sInterface.LogOptions{
+		Container: query.Get("container"),
+		Follow:    true,
+	}
+	var err error
+	if value := query.Get("tailLines"); value != "" {
+		tailLines, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || tailLines < 0 {
+			return options, fmt.Errorf("invalid tailLines %q", value)
+		}
+		options.TailLines = &tailLines
+	}
+	if value := query.Get("sinceSeconds"); value != "" {
+		sinceSeconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || sinceSeconds <= 0 {
+			return options, fmt.Errorf("invalid sinceSeconds %q", value)
+		}
+		options.SinceSeconds = &sinceSeconds
+	}
+	if value := query.Get("previous"); value != "" {
+		options.Previous, err = strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid previous %q", value)
+		}
+	}
+	if value := query.Get("follow"); value != "" {
+		options.Follow, err = strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid follow %q", value)
+		}
+	}
+	// i log del container precedente sono già completi, non c'è nulla da seguire
+	if options.Previous {
+		options.Follow = false
+	}
+	return options, nil
+}
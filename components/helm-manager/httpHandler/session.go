@@ -0,0 +1,48 @@
+package httpHandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// SessionHandler scambia la sessione della UI con cui è stata autenticata la richiesta con un
+// token firmato, da inviare come "Authorization: Bearer <token>"; le richieste autenticate con
+// un token firmato vengono rifiutate con 403
+func (h *Handlers) SessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		token, expiresAt, err := h.releases.IssueToken(r.Context(), r.Header.Get("Authorization"))
+		if err != nil {
+			writeError(w, r, err, "Error in issuing session token")
+			return
+		}
+		json_bytes, err := json.Marshal(map[string]interface{}{
+			"token":     token,
+			"expiresAt": expiresAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			writeError(w, r, err, "Error in issuing session token")
+			return
+		}
+		writeMessage(w, string(json_bytes))
+	}
+}
+
+// RevokeSessionHandler revoca il token firmato usato per la richiesta (logout)
+func (h *Handlers) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		err := h.releases.RevokeToken(r.Context(), r.Header.Get("Authorization"))
+		if err != nil {
+			writeError(w, r, err, "Error in revoking session token")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// JWKSHandler pubblica le chiavi pubbliche dei token firmati, non richiede autenticazione
+func (h *Handlers) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": h.releases.JWKS()})
+	}
+}
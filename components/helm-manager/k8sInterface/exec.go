@@ -0,0 +1,68 @@
+package k8sInterface
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	v1n "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// ExecOptions descrive il comando da eseguire in un container e gli stream collegati
+type ExecOptions struct {
+	Container string
+	Command   []string
+	TTY       bool
+	Stdin     io.Reader
+	Stdout    io.Writer
+	Stderr    io.Writer
+	// dimensioni del terminale, lette ad ogni ridimensionamento; può essere nil
+	SizeQueue remotecommand.TerminalSizeQueue
+}
+
+// ExecInPod esegue un comando nel pod usando l'executor SPDY di client-go, come kubectl exec.
+// La funzione ritorna quando il comando termina o ctx viene cancellato
+func (c *Client) ExecInPod(ctx context.Context, namespace string, podName string, options ExecOptions) error {
+	if c.restConfig == nil {
+		return fmt.Errorf("exec is not supported by this client")
+	}
+	// il pod deve esistere nel namespace indicato, così non si può uscire dal namespace della release
+	_, err := c.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		log.Println("Error getting pod: ", err.Error())
+		return err
+	}
+	req := c.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("exec").
+		VersionedParams(&v1n.PodExecOptions{
+			Container: options.Container,
+			Command:   options.Command,
+			Stdin:     options.Stdin != nil,
+			Stdout:    options.Stdout != nil,
+			Stderr:    options.Stderr != nil && !options.TTY,
+			TTY:       options.TTY,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(c.restConfig, "POST", req.URL())
+	if err != nil {
+		log.Println("Error creating executor: ", err.Error())
+		return err
+	}
+	streamOptions := remotecommand.StreamOptions{
+		Stdin:             options.Stdin,
+		Stdout:            options.Stdout,
+		Tty:               options.TTY,
+		TerminalSizeQueue: options.SizeQueue,
+	}
+	// con il TTY stdout e stderr sono uniti dal container
+	if !options.TTY {
+		streamOptions.Stderr = options.Stderr
+	}
+	return executor.StreamWithContext(ctx, streamOptions)
+}
@@ -0,0 +1,23 @@
+package k8sInterface
+
+import (
+	"helm3-manager/config"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// NewFakeClient crea un Client sul clientset fake di client-go, popolato con objects;
+// ExecInPod non è disponibile perché richiede un vero API server
+func NewFakeClient(namespacePolicy config.NamespacePolicy, objects ...runtime.Object) *Client {
+	return &Client{
+		clientset:       fake.NewSimpleClientset(objects...),
+		namespacePolicy: namespacePolicy,
+	}
+}
+
+// Clientset restituisce il clientset usato dal Client, per controllare gli oggetti creati
+func (c *Client) Clientset() kubernetes.Interface {
+	return c.clientset
+}
@@ -0,0 +1,158 @@
+package k8sInterface
+
+import (
+	"context"
+	"helm3-manager/config"
+	"log"
+
+	v1n "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// label delle ConfigMap e dei Secret creati da ApplyFiles, usata per eliminare quelli non più usati
+const filesLabel = "packs/files"
+
+// FilesObject è una ConfigMap, o un Secret se Secret è vero, con i file di una release
+type FilesObject struct {
+	Name   string
+	Secret bool
+	Data   map[string][]byte
+}
+
+// ApplyFiles crea o aggiorna nel namespace le ConfigMap e i Secret indicati ed elimina quelli
+// creati in precedenza che non compaiono più tra i values della release
+func (c *Client) ApplyFiles(ctx context.Context, namespace string, objects []FilesObject) error {
+	configMaps := make(map[string]bool)
+	secrets := make(map[string]bool)
+	for _, object := range objects {
+		var err error
+		if object.Secret {
+			secrets[object.Name] = true
+			err = c.applySecret(ctx, namespace, object)
+		} else {
+			configMaps[object.Name] = true
+			err = c.applyConfigMap(ctx, namespace, object)
+		}
+		if err != nil {
+			log.Println("Error applying files", object.Name, ":", err.Error())
+			return err
+		}
+	}
+	return c.pruneFiles(ctx, namespace, configMaps, secrets)
+}
+
+func filesObjectMeta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{filesLabel: "true"},
+	}
+}
+
+func (c *Client) applyConfigMap(ctx context.Context, namespace string, object FilesObject) error {
+	configMaps := c.clientset.CoreV1().ConfigMaps(namespace)
+	configMap := &v1n.ConfigMap{
+		ObjectMeta: filesObjectMeta(object.Name),
+		BinaryData: object.Data,
+	}
+	_, err := configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, err := configMaps.Get(ctx, object.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing.Labels = configMap.Labels
+		existing.Data = nil
+		existing.BinaryData = object.Data
+		_, err = configMaps.Update(ctx, existing, metav1.UpdateOptions{})
+		return err
+	}
+	return err
+}
+
+func (c *Client) applySecret(ctx context.Context, namespace string, object FilesObject) error {
+	secrets := c.clientset.CoreV1().Secrets(namespace)
+	secret := &v1n.Secret{
+		ObjectMeta: filesObjectMeta(object.Name),
+		Type:       v1n.SecretTypeOpaque,
+		Data:       object.Data,
+	}
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, err := secrets.Get(ctx, object.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		// i secret di Helm hanno un'altra label e non vengono mai sovrascritti
+		if existing.Labels[filesLabel] != "true" {
+			return apierrors.NewAlreadyExists(v1n.Resource("secrets"), object.Name)
+		}
+		existing.Data = object.Data
+		_, err = secrets.Update(ctx, existing, metav1.UpdateOptions{})
+		return err
+	}
+	return err
+}
+
+func (c *Client) pruneFiles(ctx context.Context, namespace string, configMaps map[string]bool, secrets map[string]bool) error {
+	selector := metav1.ListOptions{LabelSelector: filesLabel + "=true"}
+	configMapList, err := c.clientset.CoreV1().ConfigMaps(namespace).List(ctx, selector)
+	if err != nil {
+		log.Println("Error listing configmaps: ", err.Error())
+		return err
+	}
+	for _, configMap := range configMapList.Items {
+		if configMaps[configMap.Name] {
+			continue
+		}
+		err = c.clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, configMap.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Println("Error deleting configmap: ", err.Error())
+			return err
+		}
+	}
+	secretList, err := c.clientset.CoreV1().Secrets(namespace).List(ctx, selector)
+	if err != nil {
+		log.Println("Error listing secrets: ", err.Error())
+		return err
+	}
+	for _, secret := range secretList.Items {
+		if secrets[secret.Name] {
+			continue
+		}
+		err = c.clientset.CoreV1().Secrets(namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Println("Error deleting secret: ", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureFilesClaim crea, se non esiste già, la PersistentVolumeClaim in cui gli init container
+// copiano le cartelle caricate; la claim resta nel namespace fino all'eliminazione della release
+func (c *Client) EnsureFilesClaim(ctx context.Context, namespace string, name string, conf config.FilesConfig) error {
+	size, err := resource.ParseQuantity(conf.ClaimSize)
+	if err != nil {
+		return err
+	}
+	claim := &v1n.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1n.PersistentVolumeClaimSpec{
+			AccessModes: []v1n.PersistentVolumeAccessMode{v1n.PersistentVolumeAccessMode(conf.AccessMode)},
+			Resources: v1n.VolumeResourceRequirements{
+				Requests: v1n.ResourceList{v1n.ResourceStorage: size},
+			},
+		},
+	}
+	if conf.StorageClass != "" {
+		claim.Spec.StorageClassName = &conf.StorageClass
+	}
+	_, err = c.clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, claim, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		log.Println("Error creating files claim: ", err.Error())
+		return err
+	}
+	return nil
+}
@@ -2,92 +2,124 @@ package k8sInterface
 
 import (
 	"context"
+	"helm3-manager/config"
 	"io"
 	"log"
-	"os"
-	"path/filepath"
 	"strings"
 
 	v1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	v1n "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 )
 
-func CreateNamespaceIfNotExists(namespace string) error {
-	clientset, err := GetKubernetesClientSet(GetKubeConfig())
+// Cluster contiene le operazioni sul cluster usate per gestire i namespace e i pod delle release
+type Cluster interface {
+	CreateNamespaceIfNotExists(ctx context.Context, namespace string) error
+	RemoveNamespaceIfExists(ctx context.Context, namespace string) error
+	NamespaceExists(ctx context.Context, namespace string) (bool, error)
+	ApplyFiles(ctx context.Context, namespace string, objects []FilesObject) error
+	EnsureFilesClaim(ctx context.Context, namespace string, name string, conf config.FilesConfig) error
+	GetDeploymentsDetails(ctx context.Context, namespace string) ([]map[string]interface{}, error)
+	GetLogsFromPods(ctx context.Context, namespace string, podName string) (string, error)
+	StreamLogsFromPod(ctx context.Context, namespace string, podName string, options LogOptions) (io.ReadCloser, error)
+	ExecInPod(ctx context.Context, namespace string, podName string, options ExecOptions) error
+}
+
+// Client implementa Cluster con client-go
+type Client struct {
+	clientset kubernetes.Interface
+	// necessaria per l'executor SPDY di exec, nil se il clientset non parla con un vero API server
+	restConfig      *rest.Config
+	namespacePolicy config.NamespacePolicy
+}
+
+// NewClient crea un Client per il cluster raggiunto con restConfig; i limiti in namespacePolicy
+// vengono applicati ad ogni namespace creato
+func NewClient(restConfig *rest.Config, namespacePolicy config.NamespacePolicy) (*Client, error) {
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
+		log.Println("Error creating Kubernetes client: ", err.Error())
+		return nil, err
+	}
+	return &Client{
+		clientset:       clientset,
+		restConfig:      restConfig,
+		namespacePolicy: namespacePolicy,
+	}, nil
+}
+
+// CreateNamespaceIfNotExists crea il namespace della release e applica ResourceQuota,
+// LimitRange e NetworkPolicy, aggiornandole se il namespace esiste già; gli errori di Get
+// diversi da NotFound (permessi, timeout) vengono restituiti senza tentare la creazione
+func (c *Client) CreateNamespaceIfNotExists(ctx context.Context, namespace string) error {
+	ns, err := c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
-	_, err = clientset.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
-	if err != nil {
-		ns := &v1n.Namespace{
+	if apierrors.IsNotFound(err) {
+		ns = &v1n.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: namespace,
+				Name:   namespace,
+				Labels: map[string]string{releaseNamespaceLabel: "true"},
 			},
 		}
-		_, err = clientset.CoreV1().Namespaces().Create(context.Background(), ns, metav1.CreateOptions{})
+		_, err = c.clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+	} else if ns.Labels[releaseNamespaceLabel] != "true" {
+		// namespace creato prima dell'introduzione della label
+		if ns.Labels == nil {
+			ns.Labels = make(map[string]string)
+		}
+		ns.Labels[releaseNamespaceLabel] = "true"
+		_, err = c.clientset.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{})
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return applyNamespacePolicy(ctx, c.clientset, namespace, c.namespacePolicy)
 }
 
-func RemoveNamespaceIfExists(namespace string) error {
-	clientset, err := GetKubernetesClientSet(GetKubeConfig())
-	if err != nil {
-		return err
-	}
-	err = clientset.CoreV1().Namespaces().Delete(context.Background(), namespace, metav1.DeleteOptions{})
-	if err != nil {
+// RemoveNamespaceIfExists elimina il namespace, un namespace già assente non è un errore
+// (es. una release mai installata)
+func (c *Client) RemoveNamespaceIfExists(ctx context.Context, namespace string) error {
+	err := c.clientset.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
 	return nil
 }
 
-func GetKubeConfig() string {
-	var kube_config = os.Getenv("KUBECONFIG")
-	if kube_config == "" {
-		kube_config = filepath.Join(homedir.HomeDir(), ".kube", "config")
+// NamespaceExists indica se il namespace è già presente nel cluster, anche se non appartiene a una release
+func (c *Client) NamespaceExists(ctx context.Context, namespace string) (bool, error) {
+	_, err := c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
 	}
-	return kube_config
-}
-func GetKubernetesClientSet(kube_config string) (*kubernetes.Clientset, error) {
-	conf, err := clientcmd.BuildConfigFromFlags("", kube_config)
 	if err != nil {
-		log.Println("Error building kubeconfig: ", err.Error())
-		return nil, err
-
+		log.Println("Could not get namespace", err)
+		return false, err
 	}
-	clientset, err := kubernetes.NewForConfig(conf)
-	if err != nil {
-		log.Println("Error creating Kubernetes client: ", err.Error())
-		return nil, err
-	}
-	return clientset, nil
+	return true, nil
 }
 
-func GetDeploymentsFromNamespace(namespace string) (*v1.DeploymentList, error) {
-	clientset, err := GetKubernetesClientSet(GetKubeConfig())
-	if err != nil {
-		return nil, err
-	}
-	deployments, err := clientset.AppsV1().Deployments(namespace).List(context.Background(), metav1.ListOptions{})
+func (c *Client) GetDeploymentsFromNamespace(ctx context.Context, namespace string) (*v1.DeploymentList, error) {
+	deployments, err := c.clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		log.Println("Error getting deployments: ", err.Error())
 		return nil, err
 	}
 	return deployments, nil
 }
-func GetDeploymentFromNamespace(namespace string, deploymentName string) (*v1.Deployment, error) {
-	clientset, err := GetKubernetesClientSet(GetKubeConfig())
-	if err != nil {
-		return nil, err
-	}
-	deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.Background(), deploymentName, metav1.GetOptions{})
+func (c *Client) GetDeploymentFromNamespace(ctx context.Context, namespace string, deploymentName string) (*v1.Deployment, error) {
+	deployment, err := c.clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
 	if err != nil {
 		log.Println("Error getting deployment: ", err.Error())
 		return nil, err
@@ -95,12 +127,8 @@ func GetDeploymentFromNamespace(namespace string, deploymentName string) (*v1.De
 	return deployment, nil
 }
 
-func GetServicesFromDeployment(namespace string, deploymentName string) (*v1n.ServiceList, error) {
-	clientset, err := GetKubernetesClientSet(GetKubeConfig())
-	if err != nil {
-		return nil, err
-	}
-	services, err := clientset.CoreV1().Services(namespace).List(context.Background(), metav1.ListOptions{
+func (c *Client) GetServicesFromDeployment(ctx context.Context, namespace string, deploymentName string) (*v1n.ServiceList, error) {
+	services, err := c.clientset.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{
 		LabelSelector: "app=" + deploymentName,
 	})
 	if err != nil {
@@ -110,12 +138,8 @@ func GetServicesFromDeployment(namespace string, deploymentName string) (*v1n.Se
 	return services, nil
 }
 
-func GetPodsFromDeployment(namespace string, deploymentName string) (*v1n.PodList, error) {
-	clientset, err := GetKubernetesClientSet(GetKubeConfig())
-	if err != nil {
-		return nil, err
-	}
-	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+func (c *Client) GetPodsFromDeployment(ctx context.Context, namespace string, deploymentName string) (*v1n.PodList, error) {
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
 		LabelSelector: "app=" + deploymentName,
 	})
 	if err != nil {
@@ -125,14 +149,10 @@ func GetPodsFromDeployment(namespace string, deploymentName string) (*v1n.PodLis
 	return pods, nil
 }
 
-func GetLogsFromPods(namespace string, podName string) (string, error) {
-	clientset, err := GetKubernetesClientSet(GetKubeConfig())
-	if err != nil {
-		return "", err
-	}
+func (c *Client) GetLogsFromPods(ctx context.Context, namespace string, podName string) (string, error) {
 	podLogOptions := v1n.PodLogOptions{}
-	req := clientset.CoreV1().Pods(namespace).GetLogs(podName, &podLogOptions)
-	podLogs, err := req.Stream(context.Background())
+	req := c.clientset.CoreV1().Pods(namespace).GetLogs(podName, &podLogOptions)
+	podLogs, err := req.Stream(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -145,11 +165,43 @@ func GetLogsFromPods(namespace string, podName string) (string, error) {
 	return buf.String(), nil
 }
 
-func GetPortsFromDeployment(namespace string, deploymentName string) ([]map[string]interface{}, error) {
-	services, err := GetServicesFromDeployment(namespace, deploymentName)
+// LogOptions seleziona quali log di un pod leggere in streaming
+type LogOptions struct {
+	Container    string
+	TailLines    *int64
+	SinceSeconds *int64
+	Previous     bool
+	Follow       bool
+}
+
+// StreamLogsFromPod apre lo stream dei log del pod, che resta aperto finché ctx non viene
+// cancellato se Follow è attivo; il chiamante deve chiudere lo stream
+func (c *Client) StreamLogsFromPod(ctx context.Context, namespace string, podName string, options LogOptions) (io.ReadCloser, error) {
+	podLogOptions := v1n.PodLogOptions{
+		Container:    options.Container,
+		TailLines:    options.TailLines,
+		SinceSeconds: options.SinceSeconds,
+		Previous:     options.Previous,
+		Follow:       options.Follow,
+	}
+	req := c.clientset.CoreV1().Pods(namespace).GetLogs(podName, &podLogOptions)
+	podLogs, err := req.Stream(ctx)
+	if err != nil {
+		log.Println("Error streaming pod logs: ", err.Error())
+		return nil, err
+	}
+	return podLogs, nil
+}
+
+func (c *Client) GetPortsFromDeployment(ctx context.Context, namespace string, deploymentName string) ([]map[string]interface{}, error) {
+	services, err := c.GetServicesFromDeployment(ctx, namespace, deploymentName)
 	if err != nil {
 		return nil, err
 	}
+	return portsFromServices(services), nil
+}
+
+func portsFromServices(services *v1n.ServiceList) []map[string]interface{} {
 	ports := make([]map[string]interface{}, 0)
 	for _, service := range services.Items {
 		for _, port := range service.Spec.Ports {
@@ -167,48 +219,151 @@ func GetPortsFromDeployment(namespace string, deploymentName string) ([]map[stri
 			})
 		}
 	}
-	return ports, nil
+	return ports
 }
 
-func GetDeploymentsDetails(namespace string) ([]map[string]interface{}, error) {
-	deployments, err := GetDeploymentsFromNamespace(namespace)
+func (c *Client) GetStatefulSetsFromNamespace(ctx context.Context, namespace string) (*v1.StatefulSetList, error) {
+	statefulSets, err := c.clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
+		log.Println("Error getting statefulsets: ", err.Error())
 		return nil, err
 	}
-	deploymentsDetails, err := extractDetails(namespace, deployments.Items...)
+	return statefulSets, nil
+}
+
+func (c *Client) GetJobsFromNamespace(ctx context.Context, namespace string) (*batchv1.JobList, error) {
+	jobs, err := c.clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
+		log.Println("Error getting jobs: ", err.Error())
 		return nil, err
 	}
-	return deploymentsDetails, nil
+	return jobs, nil
+}
+
+// workload è un Deployment, uno StatefulSet o un Job con lo stato delle sue repliche
+type workload struct {
+	name   string
+	status map[string]interface{}
+}
+
+// i Deployment sono pronti quando tutte le repliche richieste superano il readinessProbe
+func deploymentWorkload(deployment v1.Deployment) workload {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return workload{deployment.Name, map[string]interface{}{
+		"replicas":      replicas,
+		"readyReplicas": deployment.Status.ReadyReplicas,
+		"ready":         deployment.Status.ReadyReplicas >= replicas,
+	}}
+}
+
+func statefulSetWorkload(statefulSet v1.StatefulSet) workload {
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	return workload{statefulSet.Name, map[string]interface{}{
+		"replicas":      replicas,
+		"readyReplicas": statefulSet.Status.ReadyReplicas,
+		"ready":         statefulSet.Status.ReadyReplicas >= replicas,
+	}}
+}
+
+// i Job sono pronti quando hanno completato tutte le esecuzioni
+func jobWorkload(job batchv1.Job) workload {
+	completions := int32(1)
+	if job.Spec.Completions != nil {
+		completions = *job.Spec.Completions
+	}
+	readyReplicas := int32(0)
+	if job.Status.Ready != nil {
+		readyReplicas = *job.Status.Ready
+	}
+	return workload{job.Name, map[string]interface{}{
+		"replicas":      completions,
+		"readyReplicas": readyReplicas,
+		"active":        job.Status.Active,
+		"succeeded":     job.Status.Succeeded,
+		"failed":        job.Status.Failed,
+		"ready":         job.Status.Succeeded >= completions,
+	}}
+}
+
+// GetDeploymentsDetails restituisce i dettagli dei Deployment, degli StatefulSet e dei Job del
+// namespace, con il numero di repliche pronte
+func (c *Client) GetDeploymentsDetails(ctx context.Context, namespace string) ([]map[string]interface{}, error) {
+	deployments, err := c.GetDeploymentsFromNamespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	workloads := make([]workload, 0, len(deployments.Items))
+	for _, deployment := range deployments.Items {
+		workloads = append(workloads, deploymentWorkload(deployment))
+	}
+	deploymentsDetails, err := c.extractDetails(ctx, namespace, "Deployment", workloads...)
+	if err != nil {
+		return nil, err
+	}
+	statefulSets, err := c.GetStatefulSetsFromNamespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	workloads = make([]workload, 0, len(statefulSets.Items))
+	for _, statefulSet := range statefulSets.Items {
+		workloads = append(workloads, statefulSetWorkload(statefulSet))
+	}
+	statefulSetsDetails, err := c.extractDetails(ctx, namespace, "StatefulSet", workloads...)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := c.GetJobsFromNamespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	workloads = make([]workload, 0, len(jobs.Items))
+	for _, job := range jobs.Items {
+		workloads = append(workloads, jobWorkload(job))
+	}
+	jobsDetails, err := c.extractDetails(ctx, namespace, "Job", workloads...)
+	if err != nil {
+		return nil, err
+	}
+	deploymentsDetails = append(deploymentsDetails, statefulSetsDetails...)
+	return append(deploymentsDetails, jobsDetails...), nil
 }
 
-func GetDeploymentDetails(namespace string, deploymentName string) (map[string]interface{}, error) {
-	deployment, err := GetDeploymentFromNamespace(namespace, deploymentName)
+func (c *Client) GetDeploymentDetails(ctx context.Context, namespace string, deploymentName string) (map[string]interface{}, error) {
+	deployment, err := c.GetDeploymentFromNamespace(ctx, namespace, deploymentName)
 	if err != nil {
 		return nil, err
 	}
-	deploymentsDetails, err := extractDetails(namespace, *deployment)
+	deploymentsDetails, err := c.extractDetails(ctx, namespace, "Deployment", deploymentWorkload(*deployment))
 	if err != nil {
 		return nil, err
 	}
 	return deploymentsDetails[0], nil
 }
 
-func extractDetails(namespace string, deployments ...v1.Deployment) ([]map[string]interface{}, error) {
+// extractDetails legge service e pod dei workload indicati, che il template etichetta con app=<nome>
+func (c *Client) extractDetails(ctx context.Context, namespace string, kind string, workloads ...workload) ([]map[string]interface{}, error) {
 	deploymentsDetails := make([]map[string]interface{}, 0)
-	for _, deployment := range deployments {
+	for _, w := range workloads {
 		deploymentDetails := make(map[string]interface{})
-		deploymentDetails["name"] = deployment.Name
-		ports, err := GetPortsFromDeployment(namespace, deployment.Name)
-		deploymentDetails["ports"] = ports
-		if err != nil {
-			return nil, err
+		for key, value := range w.status {
+			deploymentDetails[key] = value
 		}
-		deploymentDetails["pods"], err = GetPodsFromDeployment(namespace, deployment.Name)
+		deploymentDetails["name"] = w.name
+		deploymentDetails["kind"] = kind
+		// i service vengono letti una sola volta e usati anche per le porte
+		services, err := c.GetServicesFromDeployment(ctx, namespace, w.name)
 		if err != nil {
 			return nil, err
 		}
-		deploymentDetails["services"], err = GetServicesFromDeployment(namespace, deployment.Name)
+		deploymentDetails["ports"] = portsFromServices(services)
+		deploymentDetails["services"] = services
+		deploymentDetails["pods"], err = c.GetPodsFromDeployment(ctx, namespace, w.name)
 		if err != nil {
 			return nil, err
 		}
@@ -216,3 +371,182 @@ func extractDetails(namespace string, deployments ...v1.Deployment) ([]map[strin
 	}
 	return deploymentsDetails, nil
 }
+
+// DescribeManifests decodifica i manifest generati e ne estrae tipo, nome, porte e volumi
+func DescribeManifests(manifests ...string) ([]map[string]interface{}, error) {
+	decoder := scheme.Codecs.UniversalDeserializer()
+	objects := make([]map[string]interface{}, 0)
+	for _, manifest := range manifests {
+		if isEmptyManifest(manifest) {
+			// il template lascia documenti con il solo commento di Helm, ignorati anche all'install
+			continue
+		}
+		obj, gvk, err := decoder.Decode([]byte(manifest), nil, nil)
+		if err != nil {
+			log.Println("Error decoding manifest: ", err.Error())
+			return nil, err
+		}
+		object := map[string]interface{}{
+			"kind":    gvk.Kind,
+			"ports":   make([]map[string]interface{}, 0),
+			"volumes": make([]map[string]interface{}, 0),
+		}
+		switch o := obj.(type) {
+		case *v1.Deployment:
+			object["name"] = o.Name
+			object["ports"], object["volumes"] = describePodSpec(o.Spec.Template.Spec)
+		case *batchv1.Job:
+			object["name"] = o.Name
+			object["ports"], object["volumes"] = describePodSpec(o.Spec.Template.Spec)
+		case *v1.StatefulSet:
+			object["name"] = o.Name
+			object["ports"], object["volumes"] = describePodSpec(o.Spec.Template.Spec)
+			// i volumi persistenti dello StatefulSet non sono tra i volumi del pod
+			mountPaths := containerMountPaths(o.Spec.Template.Spec)
+			volumes := object["volumes"].([]map[string]interface{})
+			for _, claim := range o.Spec.VolumeClaimTemplates {
+				volumes = append(volumes, describeClaim(claim.Name, mountPaths[claim.Name], claim.Spec))
+			}
+			object["volumes"] = volumes
+		case *v1n.PersistentVolumeClaim:
+			object["name"] = o.Name
+			object["volumes"] = []map[string]interface{}{describeClaim(o.Name, "", o.Spec)}
+		case *v1n.Service:
+			object["name"] = o.Name
+			object["type"] = o.Spec.Type
+			ports := make([]map[string]interface{}, 0)
+			for _, port := range o.Spec.Ports {
+				p := map[string]interface{}{
+					"name":     port.Name,
+					"port":     port.Port,
+					"target":   port.TargetPort.IntVal,
+					"protocol": port.Protocol,
+				}
+				if port.NodePort != 0 {
+					p["nodePort"] = port.NodePort
+				}
+				ports = append(ports, p)
+			}
+			object["ports"] = ports
+		default:
+			accessor, err := meta.Accessor(obj)
+			if err == nil {
+				object["name"] = accessor.GetName()
+			}
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// PodTemplate è il pod creato da un Deployment, uno StatefulSet o un Job, con il numero di pod
+// che possono essere in esecuzione contemporaneamente
+type PodTemplate struct {
+	Kind     string
+	Name     string
+	Replicas int
+	Spec     v1n.PodSpec
+}
+
+// PodTemplates decodifica i manifest generati e restituisce i pod dei workload
+func PodTemplates(manifests ...string) ([]PodTemplate, error) {
+	decoder := scheme.Codecs.UniversalDeserializer()
+	templates := make([]PodTemplate, 0)
+	for _, manifest := range manifests {
+		if isEmptyManifest(manifest) {
+			continue
+		}
+		obj, _, err := decoder.Decode([]byte(manifest), nil, nil)
+		if err != nil {
+			log.Println("Error decoding manifest: ", err.Error())
+			return nil, err
+		}
+		switch o := obj.(type) {
+		case *v1.Deployment:
+			templates = append(templates, PodTemplate{Kind: "Deployment", Name: o.Name, Replicas: replicaCount(o.Spec.Replicas), Spec: o.Spec.Template.Spec})
+		case *v1.StatefulSet:
+			templates = append(templates, PodTemplate{Kind: "StatefulSet", Name: o.Name, Replicas: replicaCount(o.Spec.Replicas), Spec: o.Spec.Template.Spec})
+		case *batchv1.Job:
+			templates = append(templates, PodTemplate{Kind: "Job", Name: o.Name, Replicas: replicaCount(o.Spec.Parallelism), Spec: o.Spec.Template.Spec})
+		}
+	}
+	return templates, nil
+}
+
+// come in Kubernetes, replicas e parallelism assenti valgono 1
+func replicaCount(replicas *int32) int {
+	if replicas == nil {
+		return 1
+	}
+	return int(*replicas)
+}
+
+func describePodSpec(spec v1n.PodSpec) ([]map[string]interface{}, []map[string]interface{}) {
+	ports := make([]map[string]interface{}, 0)
+	volumes := make([]map[string]interface{}, 0)
+	for _, container := range spec.Containers {
+		for _, port := range container.Ports {
+			ports = append(ports, map[string]interface{}{
+				"name":      port.Name,
+				"port":      port.ContainerPort,
+				"container": container.Name,
+			})
+		}
+	}
+	mountPaths := containerMountPaths(spec)
+	for _, volume := range spec.Volumes {
+		v := map[string]interface{}{
+			"name":      volume.Name,
+			"mountPath": mountPaths[volume.Name],
+		}
+		if volume.HostPath != nil {
+			v["hostPath"] = volume.HostPath.Path
+		}
+		if volume.PersistentVolumeClaim != nil {
+			v["persistentVolumeClaim"] = volume.PersistentVolumeClaim.ClaimName
+		}
+		if volume.ConfigMap != nil {
+			v["configMap"] = volume.ConfigMap.Name
+		}
+		if volume.Secret != nil {
+			v["secret"] = volume.Secret.SecretName
+		}
+		volumes = append(volumes, v)
+	}
+	return ports, volumes
+}
+
+func isEmptyManifest(manifest string) bool {
+	for _, line := range strings.Split(manifest, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
+
+func containerMountPaths(spec v1n.PodSpec) map[string]string {
+	mountPaths := make(map[string]string)
+	for _, container := range spec.Containers {
+		for _, mount := range container.VolumeMounts {
+			mountPaths[mount.Name] = mount.MountPath
+		}
+	}
+	return mountPaths
+}
+
+func describeClaim(name string, mountPath string, spec v1n.PersistentVolumeClaimSpec) map[string]interface{} {
+	claim := map[string]interface{}{
+		"name":                  name,
+		"mountPath":             mountPath,
+		"persistentVolumeClaim": name,
+	}
+	if storage, ok := spec.Resources.Requests[v1n.ResourceStorage]; ok {
+		claim["size"] = storage.String()
+	}
+	if spec.StorageClassName != nil {
+		claim["storageClass"] = *spec.StorageClassName
+	}
+	return claim
+}
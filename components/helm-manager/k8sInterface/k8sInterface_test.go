@@ -0,0 +1,56 @@
+package k8sInterface
+
+import (
+	"context"
+	"errors"
+	"helm3-manager/config"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCreateNamespaceIfNotExists(t *testing.T) {
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "packs-demo", errors.New("rbac"))
+	tests := []struct {
+		name string
+		// errore restituito dal Get del namespace, nil per il comportamento del clientset fake
+		getErr      error
+		wantErr     func(error) bool
+		wantCreated bool
+	}{
+		{name: "not found", wantCreated: true},
+		{name: "forbidden", getErr: forbidden, wantErr: apierrors.IsForbidden},
+		{name: "canceled", getErr: context.Canceled, wantErr: func(err error) bool { return errors.Is(err, context.Canceled) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if test.getErr != nil {
+				clientset.PrependReactor("get", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, test.getErr
+				})
+			}
+			c := &Client{clientset: clientset, namespacePolicy: config.Default().NamespacePolicy}
+			err := c.CreateNamespaceIfNotExists(context.Background(), "packs-demo")
+			if test.wantErr == nil && err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr != nil && !test.wantErr(err) {
+				t.Fatalf("CreateNamespaceIfNotExists() error = %v", err)
+			}
+			for _, action := range clientset.Actions() {
+				if action.GetVerb() == "create" && action.GetResource().Resource == "namespaces" && !test.wantCreated {
+					t.Errorf("namespace created after a %v error", test.getErr)
+				}
+			}
+			_, err = clientset.Tracker().Get(schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, "", "packs-demo")
+			if (err == nil) != test.wantCreated {
+				t.Errorf("namespace exists = %v, want %v", err == nil, test.wantCreated)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package k8sInterface
+
+import (
+	"context"
+	"helm3-manager/config"
+	"log"
+
+	v1n "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// label applicata ai namespace delle release, usata dalla NetworkPolicy per riconoscerli
+const releaseNamespaceLabel = "packs/release"
+
+const (
+	quotaName         = "packs-quota"
+	limitRangeName    = "packs-limits"
+	networkPolicyName = "packs-isolation"
+)
+
+func applyNamespacePolicy(ctx context.Context, clientset kubernetes.Interface, namespace string, policy config.NamespacePolicy) error {
+	quota, err := buildResourceQuota(policy)
+	if err != nil {
+		log.Println("Error building resource quota: ", err.Error())
+		return err
+	}
+	err = applyResourceQuota(ctx, clientset, namespace, quota)
+	if err != nil {
+		log.Println("Error applying resource quota: ", err.Error())
+		return err
+	}
+	limitRange, err := buildLimitRange(policy)
+	if err != nil {
+		log.Println("Error building limit range: ", err.Error())
+		return err
+	}
+	err = applyLimitRange(ctx, clientset, namespace, limitRange)
+	if err != nil {
+		log.Println("Error applying limit range: ", err.Error())
+		return err
+	}
+	err = applyNetworkPolicy(ctx, clientset, namespace, buildNetworkPolicy(policy))
+	if err != nil {
+		log.Println("Error applying network policy: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func buildResourceQuota(policy config.NamespacePolicy) (*v1n.ResourceQuota, error) {
+	hard := v1n.ResourceList{}
+	for name, value := range map[v1n.ResourceName]string{
+		v1n.ResourceRequestsCPU:    policy.QuotaCPU,
+		v1n.ResourceRequestsMemory: policy.QuotaMemory,
+		v1n.ResourceLimitsCPU:      policy.QuotaCPU,
+		v1n.ResourceLimitsMemory:   policy.QuotaMemory,
+		v1n.ResourcePods:           policy.QuotaPods,
+	} {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, err
+		}
+		hard[name] = quantity
+	}
+	return &v1n.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: quotaName},
+		Spec:       v1n.ResourceQuotaSpec{Hard: hard},
+	}, nil
+}
+
+// la LimitRange assegna request e limit ai container che non li dichiarano,
+// altrimenti la ResourceQuota impedirebbe la creazione dei pod
+func buildLimitRange(policy config.NamespacePolicy) (*v1n.LimitRange, error) {
+	defaultRequest, err := parseResourceList(policy.DefaultCPURequest, policy.DefaultMemoryRequest)
+	if err != nil {
+		return nil, err
+	}
+	defaultLimit, err := parseResourceList(policy.DefaultCPULimit, policy.DefaultMemoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	return &v1n.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: limitRangeName},
+		Spec: v1n.LimitRangeSpec{
+			Limits: []v1n.LimitRangeItem{{
+				Type:           v1n.LimitTypeContainer,
+				Default:        defaultLimit,
+				DefaultRequest: defaultRequest,
+			}},
+		},
+	}, nil
+}
+
+func parseResourceList(cpu string, memory string) (v1n.ResourceList, error) {
+	cpuQuantity, err := resource.ParseQuantity(cpu)
+	if err != nil {
+		return nil, err
+	}
+	memoryQuantity, err := resource.ParseQuantity(memory)
+	if err != nil {
+		return nil, err
+	}
+	return v1n.ResourceList{
+		v1n.ResourceCPU:    cpuQuantity,
+		v1n.ResourceMemory: memoryQuantity,
+	}, nil
+}
+
+// la NetworkPolicy ammette il traffico dai pod dello stesso namespace, dai namespace che non
+// appartengono a una release (packs-proxy, ingress, kube-system) e, se sono configurati i CIDR
+// dei pod, dall'esterno del cluster per le NodePort; il traffico dalle altre release viene
+// rifiutato. Senza PodCIDRs la regola per l'esterno non viene creata: con CNI come Calico
+// l'ipBlock vale anche per gli IP dei pod e ammetterebbe le altre release
+func buildNetworkPolicy(policy config.NamespacePolicy) *networkingv1.NetworkPolicy {
+	peers := []networkingv1.NetworkPolicyPeer{
+		{PodSelector: &metav1.LabelSelector{}},
+		{NamespaceSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{
+				Key:      releaseNamespaceLabel,
+				Operator: metav1.LabelSelectorOpDoesNotExist,
+			}},
+		}},
+	}
+	if len(policy.PodCIDRs) > 0 {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{
+			CIDR:   "0.0.0.0/0",
+			Except: policy.PodCIDRs,
+		}})
+	}
+	ingress := []networkingv1.NetworkPolicyIngressRule{{From: peers}}
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: networkPolicyName},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     ingress,
+		},
+	}
+}
+
+func applyResourceQuota(ctx context.Context, clientset kubernetes.Interface, namespace string, quota *v1n.ResourceQuota) error {
+	quotas := clientset.CoreV1().ResourceQuotas(namespace)
+	_, err := quotas.Create(ctx, quota, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, err := quotas.Get(ctx, quota.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing.Spec = quota.Spec
+		_, err = quotas.Update(ctx, existing, metav1.UpdateOptions{})
+		return err
+	}
+	return err
+}
+
+func applyLimitRange(ctx context.Context, clientset kubernetes.Interface, namespace string, limitRange *v1n.LimitRange) error {
+	limitRanges := clientset.CoreV1().LimitRanges(namespace)
+	_, err := limitRanges.Create(ctx, limitRange, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, err := limitRanges.Get(ctx, limitRange.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing.Spec = limitRange.Spec
+		_, err = limitRanges.Update(ctx, existing, metav1.UpdateOptions{})
+		return err
+	}
+	return err
+}
+
+func applyNetworkPolicy(ctx context.Context, clientset kubernetes.Interface, namespace string, networkPolicy *networkingv1.NetworkPolicy) error {
+	networkPolicies := clientset.NetworkingV1().NetworkPolicies(namespace)
+	_, err := networkPolicies.Create(ctx, networkPolicy, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, err := networkPolicies.Get(ctx, networkPolicy.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing.Spec = networkPolicy.Spec
+		_, err = networkPolicies.Update(ctx, existing, metav1.UpdateOptions{})
+		return err
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package k8sInterface
+
+import (
+	"helm3-manager/config"
+	"reflect"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+func TestBuildNetworkPolicyPeers(t *testing.T) {
+	tests := []struct {
+		name     string
+		podCIDRs []string
+		// nil se la regola per il traffico esterno non deve esserci
+		wantIPBlock *networkingv1.IPBlock
+	}{
+		{name: "without pod cidrs"},
+		{
+			name:        "with pod cidrs",
+			podCIDRs:    []string{"10.244.0.0/16"},
+			wantIPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: []string{"10.244.0.0/16"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := buildNetworkPolicy(config.NamespacePolicy{PodCIDRs: test.podCIDRs})
+			if len(policy.Spec.Ingress) != 1 {
+				t.Fatalf("%d ingress rules, want 1", len(policy.Spec.Ingress))
+			}
+			peers := policy.Spec.Ingress[0].From
+			wantPeers := 2
+			if test.wantIPBlock != nil {
+				wantPeers = 3
+			}
+			if len(peers) != wantPeers {
+				t.Fatalf("%d peers, want %d", len(peers), wantPeers)
+			}
+			if peers[0].PodSelector == nil || peers[1].NamespaceSelector == nil {
+				t.Errorf("peers = %+v, want the namespace pods and the namespaces without releases", peers)
+			}
+			for _, peer := range peers[:2] {
+				if peer.IPBlock != nil {
+					t.Errorf("unexpected ipBlock peer %+v", peer.IPBlock)
+				}
+			}
+			if test.wantIPBlock != nil && !reflect.DeepEqual(peers[2].IPBlock, test.wantIPBlock) {
+				t.Errorf("ipBlock = %+v, want %+v", peers[2].IPBlock, test.wantIPBlock)
+			}
+		})
+	}
+}
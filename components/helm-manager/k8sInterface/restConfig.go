@@ -0,0 +1,101 @@
+package k8sInterface
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/homedir"
+)
+
+// LoadRestConfig restituisce la configurazione per raggiungere l'API server: dentro il cluster
+// viene usato il ServiceAccount del pod, altrimenti il kubeconfig (sviluppo locale)
+func LoadRestConfig() (*rest.Config, error) {
+	conf, err := rest.InClusterConfig()
+	if err == nil {
+		log.Println("Using in-cluster configuration")
+	} else if errors.Is(err, rest.ErrNotInCluster) {
+		kube_config := GetKubeConfig()
+		log.Println("Not running in a cluster, using kubeconfig", kube_config)
+		conf, err = clientcmd.BuildConfigFromFlags("", kube_config)
+		if err != nil {
+			log.Println("Error building kubeconfig: ", err.Error())
+			return nil, err
+		}
+	} else {
+		log.Println("Error loading in-cluster configuration: ", err.Error())
+		return nil, err
+	}
+	// i limiti di default (5 richieste al secondo) rallentano /list e /details con più release;
+	// non si imposta un Timeout perché interromperebbe gli stream dei log e le sessioni exec,
+	// la durata delle richieste è controllata dal context
+	conf.QPS = 50
+	conf.Burst = 100
+	return conf, nil
+}
+
+func GetKubeConfig() string {
+	var kube_config = os.Getenv("KUBECONFIG")
+	if kube_config == "" {
+		kube_config = filepath.Join(homedir.HomeDir(), ".kube", "config")
+	}
+	return kube_config
+}
+
+// RESTClientGetter fornisce a Helm la configurazione del cluster senza leggere un kubeconfig;
+// discovery e RESTMapper sono condivisi tra i namespace, così vengono caricati una volta sola
+type RESTClientGetter struct {
+	restConfig *rest.Config
+	discovery  discovery.CachedDiscoveryInterface
+	mapper     meta.RESTMapper
+	namespace  string
+}
+
+func NewRESTClientGetter(restConfig *rest.Config) (*RESTClientGetter, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		log.Println("Error creating discovery client: ", err.Error())
+		return nil, err
+	}
+	cached := memory.NewMemCacheClient(discoveryClient)
+	return &RESTClientGetter{
+		restConfig: restConfig,
+		discovery:  cached,
+		mapper:     restmapper.NewDeferredDiscoveryRESTMapper(cached),
+	}, nil
+}
+
+// ForNamespace restituisce un getter che usa namespace come namespace di default
+func (g *RESTClientGetter) ForNamespace(namespace string) genericclioptions.RESTClientGetter {
+	namespaced := *g
+	namespaced.namespace = namespace
+	return &namespaced
+}
+
+func (g *RESTClientGetter) ToRESTConfig() (*rest.Config, error) {
+	return rest.CopyConfig(g.restConfig), nil
+}
+
+func (g *RESTClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	return g.discovery, nil
+}
+
+func (g *RESTClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	return g.mapper, nil
+}
+
+// Helm legge dal loader solo il namespace di default
+func (g *RESTClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return clientcmd.NewDefaultClientConfig(*clientcmdapi.NewConfig(), &clientcmd.ConfigOverrides{
+		Context: clientcmdapi.Context{Namespace: g.namespace},
+	})
+}
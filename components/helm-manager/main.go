@@ -1,37 +1,156 @@
 package main
 
 import (
+	"context"
+	"helm3-manager/config"
+	"helm3-manager/helmInterface"
 	"helm3-manager/httpHandler"
+	"helm3-manager/k8sInterface"
+	"helm3-manager/models"
+	"helm3-manager/redisInterface"
 	"helm3-manager/relHandler"
+	"helm3-manager/tokenHandler"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-const listenPort = ":9000"
-
 func main() {
-	relHandler.MakeUploadDirIfNotExist()
+	conf, err := config.Load()
+	if err != nil {
+		log.Fatal("Could not load configuration: ", err)
+	}
+	restConfig, err := k8sInterface.LoadRestConfig()
+	if err != nil {
+		log.Fatal("Could not load Kubernetes configuration: ", err)
+	}
+	cluster, err := k8sInterface.NewClient(restConfig, conf.NamespacePolicy)
+	if err != nil {
+		log.Fatal("Could not create Kubernetes client: ", err)
+	}
+	restClientGetter, err := k8sInterface.NewRESTClientGetter(restConfig)
+	if err != nil {
+		log.Fatal("Could not create Kubernetes client: ", err)
+	}
+	store := redisInterface.NewClient(conf.Redis)
+	defer store.Close()
+	// le release salvate con il vecchio formato vengono convertite prima di accettare richieste
+	migrated, err := store.MigrateLegacyReleases(context.Background())
+	if err != nil {
+		log.Fatal("Could not migrate releases: ", err)
+	}
+	if migrated > 0 {
+		log.Println("Migrated", migrated, "releases to the new Redis schema")
+	}
+	var tokens *tokenHandler.Manager
+	if conf.Session.Enabled() {
+		tokens, err = tokenHandler.NewManager(conf.Session)
+		if err != nil {
+			log.Fatal("Could not load session keys: ", err)
+		}
+	}
+	releases := relHandler.NewService(conf, store, helmInterface.NewDeployer(restClientGetter.ForNamespace), cluster, tokens)
+	releases.MakeUploadDirIfNotExist()
+	handlers := httpHandler.NewHandlers(releases)
 
-	jwtVerHandler := http.HandlerFunc(httpHandler.JwtTokenVerificationHandler)
-	middlewaresSetForUpload := httpHandler.ComposeMiddlewares(jwtVerHandler, httpHandler.CorsHandler, httpHandler.UploadHandler)
-	middlewaresSetForList := httpHandler.ComposeMiddlewares(jwtVerHandler, httpHandler.CorsHandler, httpHandler.ListHandler)
-	middlewaresSetForInstall := httpHandler.ComposeMiddlewares(jwtVerHandler, httpHandler.CorsHandler, httpHandler.InstallHandler)
-	middlewaresSetForDelete := httpHandler.ComposeMiddlewares(jwtVerHandler, httpHandler.CorsHandler, httpHandler.DeleteHandler)
-	middlewaresSetForStop := httpHandler.ComposeMiddlewares(jwtVerHandler, httpHandler.CorsHandler, httpHandler.StopHandler)
-	middlewaresSetForDetails := httpHandler.ComposeMiddlewares(jwtVerHandler, httpHandler.CorsHandler, httpHandler.DetailsHandler)
-	middlewaresSetForLogs := httpHandler.ComposeMiddlewares(jwtVerHandler, httpHandler.CorsHandler, httpHandler.LogsHandler)
-	middlewaresSetForDeliveredList := httpHandler.ComposeMiddlewares(jwtVerHandler, httpHandler.CorsHandler, httpHandler.DeliveredListHandler)
-	middlewaresSetForUndelivery := httpHandler.ComposeMiddlewares(jwtVerHandler, httpHandler.CorsHandler, httpHandler.UndeliverHandler)
+	withTimeout := httpHandler.TimeoutHandler(time.Duration(conf.RequestTimeoutSeconds) * time.Second)
+	// i middleware vanno dal più interno al più esterno: ogni richiesta riceve un request id,
+	// passa per timeout e CORS e raggiunge l'handler solo se AuthHandler ha riconosciuto l'utente;
+	// access contiene i controlli sui permessi, che richiedono l'utente già autenticato
+	withMiddlewares := func(handler http.HandlerFunc, access ...func(http.Handler) http.Handler) http.Handler {
+		middlewares := append(access, handlers.AuthHandler, httpHandler.CorsHandler, withTimeout, httpHandler.RequestIDHandler)
+		return httpHandler.ComposeMiddlewares(handler, middlewares...)
+	}
+	// log in streaming e terminale restano aperti finché il client non si disconnette
+	withStreamingMiddlewares := func(handler http.HandlerFunc, access ...func(http.Handler) http.Handler) http.Handler {
+		middlewares := append(access, handlers.AuthHandler, httpHandler.CorsHandler, httpHandler.RequestIDHandler)
+		return httpHandler.ComposeMiddlewares(handler, middlewares...)
+	}
+	middlewaresSetForUpload := withMiddlewares(handlers.UploadHandler)
+	middlewaresSetForList := withMiddlewares(handlers.ListHandler)
+	middlewaresSetForInstall := withMiddlewares(handlers.InstallHandler, handlers.ReleaseAccess(relHandler.ActionManage))
+	middlewaresSetForUpgrade := withMiddlewares(handlers.UpgradeHandler, handlers.ReleaseAccess(relHandler.ActionManage))
+	middlewaresSetForHistory := withMiddlewares(handlers.HistoryHandler, handlers.ReleaseAccess(relHandler.ActionView))
+	middlewaresSetForRollback := withMiddlewares(handlers.RollbackHandler, handlers.ReleaseAccess(relHandler.ActionManage))
+	middlewaresSetForRender := withMiddlewares(handlers.RenderHandler, handlers.ReleaseAccess(relHandler.ActionView))
+	middlewaresSetForDelete := withMiddlewares(handlers.DeleteHandler, handlers.ReleaseAccess(relHandler.ActionManage))
+	middlewaresSetForStop := withMiddlewares(handlers.StopHandler, handlers.ReleaseAccess(relHandler.ActionStop))
+	middlewaresSetForDetails := withMiddlewares(handlers.DetailsHandler, handlers.ReleaseAccess(relHandler.ActionView))
+	middlewaresSetForLogs := withMiddlewares(handlers.LogsHandler, handlers.ReleaseAccess(relHandler.ActionLogs))
+	middlewaresSetForLogStream := withStreamingMiddlewares(handlers.LogStreamHandler, handlers.ReleaseAccess(relHandler.ActionLogs))
+	middlewaresSetForExec := withStreamingMiddlewares(handlers.ExecHandler, handlers.ReleaseAccess(relHandler.ActionManage))
+	middlewaresSetForDeliveredList := withMiddlewares(handlers.DeliveredListHandler, handlers.ReleaseAccess(relHandler.ActionManage))
+	middlewaresSetForUndelivery := withMiddlewares(handlers.UndeliverHandler, handlers.ReleaseAccess(relHandler.ActionManage))
+	middlewaresSetForUserRole := withMiddlewares(handlers.UserRoleHandler, httpHandler.RequireRole(models.RoleAdmin))
+	middlewaresSetForCourseMember := withMiddlewares(handlers.CourseMemberHandler, httpHandler.RequireRole(models.RoleAdmin))
+	middlewaresSetForCourseMembers := withMiddlewares(handlers.CourseMembersHandler, httpHandler.RequireRole(models.RoleTeacher, models.RoleAdmin))
+	middlewaresSetForQuota := withMiddlewares(handlers.QuotaHandler)
+	middlewaresSetForAdminQuota := withMiddlewares(handlers.AdminQuotaHandler, httpHandler.RequireRole(models.RoleAdmin))
+	middlewaresSetForSession := withMiddlewares(handlers.SessionHandler)
+	middlewaresSetForRevokeSession := withMiddlewares(handlers.RevokeSessionHandler)
+	// le chiavi pubbliche sono accessibili a tutti, servono proprio a chi non ha un token
+	middlewaresSetForJWKS := httpHandler.ComposeMiddlewares(http.HandlerFunc(handlers.JWKSHandler), httpHandler.CorsHandler, withTimeout, httpHandler.RequestIDHandler)
+	// scaricato dagli init container delle release, senza timeout perché l'archivio può essere grande
+	middlewaresSetForFiles := httpHandler.ComposeMiddlewares(http.HandlerFunc(handlers.FilesHandler), httpHandler.RequestIDHandler)
 
 	http.Handle("/upload", middlewaresSetForUpload)
 	http.Handle("/list", middlewaresSetForList)
 	http.Handle("/install", middlewaresSetForInstall)
+	http.Handle("/upgrade", middlewaresSetForUpgrade)
+	http.Handle("/history", middlewaresSetForHistory)
+	http.Handle("/rollback", middlewaresSetForRollback)
+	http.Handle("/render", middlewaresSetForRender)
 	http.Handle("/delete", middlewaresSetForDelete)
 	http.Handle("/stop", middlewaresSetForStop)
 	http.Handle("/details", middlewaresSetForDetails)
 	http.Handle("/logs", middlewaresSetForLogs)
+	http.Handle("/logs/stream", middlewaresSetForLogStream)
+	http.Handle("/exec", middlewaresSetForExec)
 	http.Handle("/delivered", middlewaresSetForDeliveredList)
 	http.Handle("/undeliver", middlewaresSetForUndelivery)
-	log.Println("Server started at port " + listenPort)
-	log.Fatal(http.ListenAndServe(listenPort, nil))
+	http.Handle("/courses", middlewaresSetForCourseMembers)
+	http.Handle("/admin/users", middlewaresSetForUserRole)
+	http.Handle("/admin/courses", middlewaresSetForCourseMember)
+	http.Handle("/quota", middlewaresSetForQuota)
+	http.Handle("/admin/quotas", middlewaresSetForAdminQuota)
+	http.Handle("/session", middlewaresSetForSession)
+	http.Handle("/session/revoke", middlewaresSetForRevokeSession)
+	http.Handle("/.well-known/jwks.json", middlewaresSetForJWKS)
+	http.Handle("/files", middlewaresSetForFiles)
+
+	// il context base viene cancellato all'avvio dello spegnimento, così i log in streaming e
+	// le sessioni exec, che non terminano da sole, vengono chiusi
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:              conf.ListenAddr,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+	server.RegisterOnShutdown(cancelBase)
+
+	stop, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Println("Server started at " + conf.ListenAddr)
+		serverErr <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-serverErr:
+		log.Println("Server stopped: ", err)
+		return
+	case <-stop.Done():
+	}
+	log.Println("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(conf.ShutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Println("Could not shut down server gracefully: ", err)
+	}
 }
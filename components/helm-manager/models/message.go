@@ -1,50 +1,18 @@
 package models
 
-import "encoding/json"
-
+// Message è la risposta json delle richieste andate a buon fine, Message contiene a sua volta
+// il json restituito da relHandler
 type Message struct {
-	// Http standard json response message
-	Message map[string]string
-}
-
-func (e *Message) setMessage(message string) {
-	if e.Message == nil {
-		e.Message = make(map[string]string)
-	}
-	e.Message["message"] = message
-}
-func (e *Message) JsonMessage(message string) string {
-	e.setMessage(message)
-	e.Message["type"] = "message"
-	return e.toJsonString()
-}
-
-func (e *Message) JsonError(messages ...interface{}) string {
-	var finalMessage string
-	for _, message := range messages {
-		switch msg := message.(type) {
-		case string:
-			finalMessage = finalMessage + " " + msg
-		case error:
-			finalMessage = finalMessage + " " + msg.Error()
-		}
-	}
-	e.setMessage(finalMessage)
-	e.Message["type"] = "error"
-	return e.toJsonString()
-}
-
-func (e *Message) JsonServerError() string {
-	errorMessage := "Internal Server Error"
-	e.setMessage(errorMessage)
-	e.Message["type"] = "serverError"
-	return e.toJsonString()
+	Type    string `json:"type"`
+	Message string `json:"message"`
 }
 
-func (e *Message) toJsonString() string {
-	byte_string, err := json.Marshal(e.Message)
-	if err != nil {
-		return ""
-	}
-	return string(byte_string)
+// APIError è l'unica risposta restituita da una richiesta fallita. Code è stabile e può essere
+// usato dai client, Message è leggibile dall'utente e Details contiene informazioni aggiuntive
+// (per esempio i campi non validi di values.yaml)
+type APIError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
 }
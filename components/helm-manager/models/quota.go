@@ -0,0 +1,29 @@
+package models
+
+// Quota contiene i limiti di un utente o di un corso. Un campo a zero (o vuoto per cpu e memoria)
+// non è impostato e viene ereditato: prima dai corsi dell'utente, poi dai default della configurazione
+type Quota struct {
+	// release caricate, attive o meno
+	MaxReleases int `json:"maxReleases,omitempty"`
+	// release installate contemporaneamente
+	MaxActiveReleases int `json:"maxActiveReleases,omitempty"`
+	// repliche totali dei componenti delle release attive
+	MaxReplicas int `json:"maxReplicas,omitempty"`
+	// somma delle request di cpu e memoria delle release attive, come quantità Kubernetes
+	MaxCPU    string `json:"maxCpu,omitempty"`
+	MaxMemory string `json:"maxMemory,omitempty"`
+}
+
+// IsEmpty indica se nessun limite è impostato
+func (q Quota) IsEmpty() bool {
+	return q == Quota{}
+}
+
+// QuotaUsage è l'utilizzo corrente delle risorse conteggiate dalle quote
+type QuotaUsage struct {
+	Releases       int    `json:"releases"`
+	ActiveReleases int    `json:"activeReleases"`
+	Replicas       int    `json:"replicas"`
+	CPU            string `json:"cpu"`
+	Memory         string `json:"memory"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	ReleaseActive   = "active"
+	ReleaseInactive = "inactive"
+)
+
+var ErrReleaseNotFound = errors.New("release not found")
+
+// Release è una release caricata da un utente. Status non viene salvato: lo stato reale
+// è quello della release Helm e viene calcolato ad ogni lettura
+type Release struct {
+	Jwt       string    `json:"jwt"`
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"createdAt"`
+	Status    string    `json:"status,omitempty"`
+	Delivered bool      `json:"delivered"`
+}
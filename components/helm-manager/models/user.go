@@ -0,0 +1,22 @@
+package models
+
+import "errors"
+
+// ruoli degli utenti, salvati su Redis per cf; un utente senza ruolo è uno studente
+const (
+	RoleStudent = "student"
+	RoleTeacher = "teacher"
+	RoleAdmin   = "admin"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// User è l'utente autenticato che ha inviato la richiesta, risolto una sola volta dal token di sessione
+type User struct {
+	Cf   string `json:"cf"`
+	Role string `json:"role"`
+}
+
+func IsValidRole(role string) bool {
+	return role == RoleStudent || role == RoleTeacher || role == RoleAdmin
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError descrive un singolo campo di values.yaml che non rispetta lo schema
+type FieldError struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+	Message  string `json:"message"`
+}
+
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldError := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", fieldError.Path, fieldError.Message))
+	}
+	return "invalid values: " + strings.Join(messages, "; ")
+}
+
+// Warning descrive una parte di un file caricato che è stata ignorata o adattata durante la
+// conversione nei values
+type Warning struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,47 @@
+package redisInterface
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// schema dei token per scaricare i file delle release:
+//   files:<jwt>             token usato dagli init container della release, finché è attiva
+
+func filesTokenKey(jwt string) string {
+	return "files:" + jwt
+}
+
+// GetFilesToken restituisce il token della release, una stringa vuota se non è stato creato
+func (c *Client) GetFilesToken(ctx context.Context, jwt string) (string, error) {
+	token, err := c.redisClient.Get(ctx, filesTokenKey(jwt)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		log.Println("(GetFilesToken)Could not get token: ", err)
+		return "", err
+	}
+	return token, nil
+}
+
+func (c *Client) SetFilesToken(ctx context.Context, jwt string, token string) error {
+	err := c.redisClient.Set(ctx, filesTokenKey(jwt), token, 0).Err()
+	if err != nil {
+		log.Println("(SetFilesToken)Could not set token: ", err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) DeleteFilesToken(ctx context.Context, jwt string) error {
+	err := c.redisClient.Del(ctx, filesTokenKey(jwt)).Err()
+	if err != nil {
+		log.Println("(DeleteFilesToken)Could not delete token: ", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+package redisInterface
+
+import (
+	"context"
+	"helm3-manager/models"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryStore implementa Store in memoria, con la stessa semantica dei comandi Redis usati;
+// serve per provare relHandler senza un server Redis
+type MemoryStore struct {
+	mu       sync.Mutex
+	values   map[string]string
+	releases map[string]models.Release
+	owners   map[string]map[string]struct{}
+	roles    map[string]string
+	courses  map[string]map[string]struct{}
+	revoked  map[string]time.Time
+	reserved map[string]time.Time
+	quotas   map[string]models.Quota
+	// set generici, come i vecchi rel-<cf> da migrare
+	sets map[string]map[string]struct{}
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		values:   make(map[string]string),
+		releases: make(map[string]models.Release),
+		owners:   make(map[string]map[string]struct{}),
+		roles:    make(map[string]string),
+		courses:  make(map[string]map[string]struct{}),
+		revoked:  make(map[string]time.Time),
+		reserved: make(map[string]time.Time),
+		quotas:   make(map[string]models.Quota),
+		sets:     make(map[string]map[string]struct{}),
+	}
+}
+
+// SetKeyValue equivale a SET, usato per creare le sessioni (token -> cf)
+func (m *MemoryStore) SetKeyValue(key string, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+}
+
+// AddSetMember equivale a SADD, usato per creare i vecchi set rel-<cf> e rel-admin
+func (m *MemoryStore) AddSetMember(key string, member string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sets[key] == nil {
+		m.sets[key] = make(map[string]struct{})
+	}
+	m.sets[key][member] = struct{}{}
+}
+
+func (m *MemoryStore) CheckPresence(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.values[key]
+	return ok, nil
+}
+
+// come GET, una chiave assente restituisce redis.Nil
+func (m *MemoryStore) GetKeyValue(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	if !ok {
+		return "", redis.Nil
+	}
+	return value, nil
+}
+
+func (m *MemoryStore) GetSession(ctx context.Context, token string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cf, ok := m.values[token]
+	if !ok {
+		return "", models.ErrSessionNotFound
+	}
+	return cf, nil
+}
+
+func (m *MemoryStore) RevokeToken(ctx context.Context, id string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[id] = time.Now().Add(ttl)
+	return nil
+}
+
+// come su Redis, una revoca scaduta non è più presente
+func (m *MemoryStore) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expiry, ok := m.revoked[id]
+	return ok && time.Now().Before(expiry), nil
+}
+
+// come SETNX con scadenza, una riserva scaduta può essere ripresa
+func (m *MemoryStore) ReserveReleaseID(ctx context.Context, jwt string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if expiry, ok := m.reserved[jwt]; ok && time.Now().Before(expiry) {
+		return false, nil
+	}
+	m.reserved[jwt] = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (m *MemoryStore) FreeReleaseID(ctx context.Context, jwt string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.reserved, jwt)
+	return nil
+}
+
+func (m *MemoryStore) SaveRelease(ctx context.Context, rel *models.Release) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := *rel
+	// come su Redis, lo stato non viene salvato
+	saved.Status = ""
+	m.releases[rel.Jwt] = saved
+	if m.owners[rel.Owner] == nil {
+		m.owners[rel.Owner] = make(map[string]struct{})
+	}
+	m.owners[rel.Owner][rel.Jwt] = struct{}{}
+	return nil
+}
+
+func (m *MemoryStore) GetRelease(ctx context.Context, jwt string) (*models.Release, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rel, ok := m.releases[jwt]
+	if !ok {
+		return nil, models.ErrReleaseNotFound
+	}
+	return &rel, nil
+}
+
+func (m *MemoryStore) GetReleasesByOwner(ctx context.Context, owner string) ([]*models.Release, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rels := make([]*models.Release, 0, len(m.owners[owner]))
+	for jwt := range m.owners[owner] {
+		if rel, ok := m.releases[jwt]; ok {
+			rels = append(rels, &rel)
+		}
+	}
+	return rels, nil
+}
+
+func (m *MemoryStore) GetDeliveredReleases(ctx context.Context) ([]*models.Release, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rels := make([]*models.Release, 0)
+	for _, rel := range m.releases {
+		if rel.Delivered {
+			rels = append(rels, &rel)
+		}
+	}
+	return rels, nil
+}
+
+func (m *MemoryStore) CountReleasesByOwner(ctx context.Context, owner string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.owners[owner])), nil
+}
+
+func (m *MemoryStore) SetDelivered(ctx context.Context, jwt string, delivered bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rel, ok := m.releases[jwt]
+	if !ok {
+		return models.ErrReleaseNotFound
+	}
+	rel.Delivered = delivered
+	m.releases[jwt] = rel
+	return nil
+}
+
+func (m *MemoryStore) DeleteRelease(ctx context.Context, rel *models.Release) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.releases, rel.Jwt)
+	delete(m.owners[rel.Owner], rel.Jwt)
+	// Redis elimina i set rimasti vuoti
+	if len(m.owners[rel.Owner]) == 0 {
+		delete(m.owners, rel.Owner)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUserRole(ctx context.Context, cf string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.roles[cf], nil
+}
+
+func (m *MemoryStore) SetUserRole(ctx context.Context, cf string, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.roles[cf] = role
+	return nil
+}
+
+func (m *MemoryStore) GetCourseMembers(ctx context.Context, course string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]string, 0, len(m.courses[course]))
+	for cf := range m.courses[course] {
+		members = append(members, cf)
+	}
+	return members, nil
+}
+
+func (m *MemoryStore) AddCourseMember(ctx context.Context, course string, cf string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.courses[course] == nil {
+		m.courses[course] = make(map[string]struct{})
+	}
+	m.courses[course][cf] = struct{}{}
+	return nil
+}
+
+func (m *MemoryStore) RemoveCourseMember(ctx context.Context, course string, cf string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.courses[course], cf)
+	if len(m.courses[course]) == 0 {
+		delete(m.courses, course)
+	}
+	return nil
+}
+
+func (m *MemoryStore) ShareCourse(ctx context.Context, cf string, other string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, members := range m.courses {
+		_, first := members[cf]
+		_, second := members[other]
+		if first && second {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) GetUserCourses(ctx context.Context, cf string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	courses := make([]string, 0)
+	for course, members := range m.courses {
+		if _, ok := members[cf]; ok {
+			courses = append(courses, course)
+		}
+	}
+	return courses, nil
+}
+
+// le quote usano le stesse chiavi di Redis
+func (m *MemoryStore) getQuota(key string) (*models.Quota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	quota := m.quotas[key]
+	return &quota, nil
+}
+
+func (m *MemoryStore) setQuota(key string, quota *models.Quota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if quota.IsEmpty() {
+		delete(m.quotas, key)
+		return nil
+	}
+	m.quotas[key] = *quota
+	return nil
+}
+
+func (m *MemoryStore) GetUserQuota(ctx context.Context, cf string) (*models.Quota, error) {
+	return m.getQuota(userQuotaKey(cf))
+}
+
+func (m *MemoryStore) SetUserQuota(ctx context.Context, cf string, quota *models.Quota) error {
+	return m.setQuota(userQuotaKey(cf), quota)
+}
+
+func (m *MemoryStore) GetCourseQuota(ctx context.Context, course string) (*models.Quota, error) {
+	return m.getQuota(courseQuotaKey(course))
+}
+
+func (m *MemoryStore) SetCourseQuota(ctx context.Context, course string, quota *models.Quota) error {
+	return m.setQuota(courseQuotaKey(course), quota)
+}
+
+// i token dei file usano le stesse chiavi di Redis
+func (m *MemoryStore) GetFilesToken(ctx context.Context, jwt string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[filesTokenKey(jwt)], nil
+}
+
+func (m *MemoryStore) SetFilesToken(ctx context.Context, jwt string, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[filesTokenKey(jwt)] = token
+	return nil
+}
+
+func (m *MemoryStore) DeleteFilesToken(ctx context.Context, jwt string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, filesTokenKey(jwt))
+	return nil
+}
+
+// MigrateLegacyReleases esegue la stessa migrazione di Client sui set creati con AddSetMember
+func (m *MemoryStore) MigrateLegacyReleases(ctx context.Context) (int, error) {
+	return migrateLegacyReleases(ctx, m)
+}
+
+func (m *MemoryStore) legacyKeys(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0)
+	for key := range m.sets {
+		if strings.HasPrefix(key, "rel-") && key != legacyAdminKey {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MemoryStore) legacyMembers(ctx context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]string, 0, len(m.sets[key]))
+	for member := range m.sets[key] {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (m *MemoryStore) deleteLegacySet(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sets, key)
+	return nil
+}
@@ -0,0 +1,98 @@
+package redisInterface
+
+import (
+	"context"
+	"helm3-manager/models"
+	"log"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// schema delle quote su Redis, i campi non impostati non vengono salvati:
+//   quota:user:<cf>         hash con i campi di models.Quota
+//   quota:course:<name>     hash con i campi di models.Quota
+
+func userQuotaKey(cf string) string {
+	return "quota:user:" + cf
+}
+
+func courseQuotaKey(course string) string {
+	return "quota:course:" + course
+}
+
+func quotaToHash(quota *models.Quota) map[string]interface{} {
+	hash := make(map[string]interface{})
+	if quota.MaxReleases > 0 {
+		hash["maxReleases"] = quota.MaxReleases
+	}
+	if quota.MaxActiveReleases > 0 {
+		hash["maxActiveReleases"] = quota.MaxActiveReleases
+	}
+	if quota.MaxReplicas > 0 {
+		hash["maxReplicas"] = quota.MaxReplicas
+	}
+	if quota.MaxCPU != "" {
+		hash["maxCpu"] = quota.MaxCPU
+	}
+	if quota.MaxMemory != "" {
+		hash["maxMemory"] = quota.MaxMemory
+	}
+	return hash
+}
+
+func quotaFromHash(hash map[string]string) *models.Quota {
+	// un campo non numerico vale come non impostato
+	maxReleases, _ := strconv.Atoi(hash["maxReleases"])
+	maxActiveReleases, _ := strconv.Atoi(hash["maxActiveReleases"])
+	maxReplicas, _ := strconv.Atoi(hash["maxReplicas"])
+	return &models.Quota{
+		MaxReleases:       maxReleases,
+		MaxActiveReleases: maxActiveReleases,
+		MaxReplicas:       maxReplicas,
+		MaxCPU:            hash["maxCpu"],
+		MaxMemory:         hash["maxMemory"],
+	}
+}
+
+func (c *Client) getQuota(ctx context.Context, key string) (*models.Quota, error) {
+	hash, err := c.redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		log.Println("(GetQuota)Could not get quota: ", err)
+		return nil, err
+	}
+	return quotaFromHash(hash), nil
+}
+
+// setQuota sostituisce l'intera quota, una quota senza limiti elimina la chiave
+func (c *Client) setQuota(ctx context.Context, key string, quota *models.Quota) error {
+	_, err := c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		if !quota.IsEmpty() {
+			pipe.HSet(ctx, key, quotaToHash(quota))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("(SetQuota)Could not set quota: ", err)
+		return err
+	}
+	return nil
+}
+
+// GetUserQuota restituisce la quota assegnata al cf, vuota se non è mai stata impostata
+func (c *Client) GetUserQuota(ctx context.Context, cf string) (*models.Quota, error) {
+	return c.getQuota(ctx, userQuotaKey(cf))
+}
+
+func (c *Client) SetUserQuota(ctx context.Context, cf string, quota *models.Quota) error {
+	return c.setQuota(ctx, userQuotaKey(cf), quota)
+}
+
+func (c *Client) GetCourseQuota(ctx context.Context, course string) (*models.Quota, error) {
+	return c.getQuota(ctx, courseQuotaKey(course))
+}
+
+func (c *Client) SetCourseQuota(ctx context.Context, course string, quota *models.Quota) error {
+	return c.setQuota(ctx, courseQuotaKey(course), quota)
+}
@@ -2,82 +2,88 @@ package redisInterface
 
 import (
 	"context"
+	"crypto/tls"
+	"helm3-manager/config"
+	"helm3-manager/models"
 	"log"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-const redisHost = "redis"
-const redisPort = "6379"
+// Store contiene le operazioni su Redis usate per salvare sessioni, release, utenti e quote; ctx è normalmente
+// il context della richiesta HTTP, così un client che si disconnette interrompe i comandi
+type Store interface {
+	GetSession(ctx context.Context, token string) (string, error)
+	RevokeToken(ctx context.Context, id string, ttl time.Duration) error
+	IsTokenRevoked(ctx context.Context, id string) (bool, error)
+	ReserveReleaseID(ctx context.Context, jwt string, ttl time.Duration) (bool, error)
+	FreeReleaseID(ctx context.Context, jwt string) error
+	SaveRelease(ctx context.Context, rel *models.Release) error
+	GetRelease(ctx context.Context, jwt string) (*models.Release, error)
+	GetReleasesByOwner(ctx context.Context, owner string) ([]*models.Release, error)
+	GetDeliveredReleases(ctx context.Context) ([]*models.Release, error)
+	CountReleasesByOwner(ctx context.Context, owner string) (int64, error)
+	SetDelivered(ctx context.Context, jwt string, delivered bool) error
+	DeleteRelease(ctx context.Context, rel *models.Release) error
+	GetUserRole(ctx context.Context, cf string) (string, error)
+	SetUserRole(ctx context.Context, cf string, role string) error
+	GetCourseMembers(ctx context.Context, course string) ([]string, error)
+	AddCourseMember(ctx context.Context, course string, cf string) error
+	RemoveCourseMember(ctx context.Context, course string, cf string) error
+	ShareCourse(ctx context.Context, cf string, other string) (bool, error)
+	GetUserCourses(ctx context.Context, cf string) ([]string, error)
+	GetUserQuota(ctx context.Context, cf string) (*models.Quota, error)
+	SetUserQuota(ctx context.Context, cf string, quota *models.Quota) error
+	GetCourseQuota(ctx context.Context, course string) (*models.Quota, error)
+	SetCourseQuota(ctx context.Context, course string, quota *models.Quota) error
+	GetFilesToken(ctx context.Context, jwt string) (string, error)
+	SetFilesToken(ctx context.Context, jwt string, token string) error
+	DeleteFilesToken(ctx context.Context, jwt string) error
+}
 
-func getNewRedisClient() *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     redisHost + ":" + redisPort,
-		Password: "", // no password
-		DB:       0,  // default DB
-	})
+// Client implementa Store su un server Redis. Il client va creato una sola volta all'avvio:
+// le connessioni vengono riutilizzate dal pool di go-redis e chiuse da Close
+type Client struct {
+	redisClient *redis.Client
 }
 
-// funzione che crea un set con chiave key e valori values
-func InsertInSet(key string, value string) error {
-	ctx := context.Background()
-	redisClient := getNewRedisClient()
-	_, err := redisClient.SAdd(ctx, key, value).Result()
-	if err != nil {
-		log.Println("(InsertInSet)Could not insert in set: ", err)
-		return err
+func NewClient(conf config.RedisConfig) *Client {
+	options := &redis.Options{
+		Addr:     conf.Addr(),
+		Password: conf.Password,
+		DB:       conf.DB,
+		PoolSize: conf.PoolSize,
+		// la scadenza del context vale anche come timeout di lettura e scrittura sulla connessione
+		ContextTimeoutEnabled: true,
 	}
-	return nil
-}
-func GetAllSetFromKey(key string) ([]string, error) {
-	ctx := context.Background()
-	redisClient := getNewRedisClient()
-	val, err := redisClient.SMembers(ctx, key).Result()
-	if err != nil {
-		log.Println("(GetAllSetFromKey)Could not get key: ", err)
-		return nil, err
+	if conf.TLS {
+		options.TLSConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: conf.TLSInsecureSkipVerify,
+		}
 	}
-	return val, nil
+	return &Client{redisClient: redis.NewClient(options)}
 }
-func GetNumberOfSetFromKey(key string) (int64, error) {
-	ctx := context.Background()
-	redisClient := getNewRedisClient()
-	val, err := redisClient.SCard(ctx, key).Result()
-	if err != nil {
-		log.Println("(GetNumberOfSetFromKey)Could not get key: ", err)
-		return 0, err
-	}
-	return val, nil
+
+// Close chiude tutte le connessioni del pool, va chiamata allo spegnimento del server
+func (c *Client) Close() error {
+	return c.redisClient.Close()
 }
 
-func CheckPresence(key string) (bool, error) {
-	ctx := context.Background()
-	redisClient := getNewRedisClient()
-	val, err := redisClient.Exists(ctx, key).Result()
+func (c *Client) CheckPresence(ctx context.Context, key string) (bool, error) {
+	val, err := c.redisClient.Exists(ctx, key).Result()
 	if err != nil {
 		log.Println("(CheckPresence)Could not check presence: ", err)
 		return false, err
 	}
 	return val == 1, nil
 }
-func GetKeyValue(key string) (string, error) {
-	ctx := context.Background()
-	redisClient := getNewRedisClient()
-	val, err := redisClient.Get(ctx, key).Result()
+func (c *Client) GetKeyValue(ctx context.Context, key string) (string, error) {
+	val, err := c.redisClient.Get(ctx, key).Result()
 	if err != nil {
 		log.Println("(GetKeyValue)Could not get key: ", err)
 		return "", err
 	}
 	return val, nil
 }
-
-func DeleteFromSet(cf string, rel string) error {
-	ctx := context.Background()
-	redisClient := getNewRedisClient()
-	_, err := redisClient.SRem(ctx, "rel-"+cf, rel).Result()
-	if err != nil {
-		log.Println("(DeleteFromSet)Could not delete from set: ", err)
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,342 @@
+package redisInterface
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"helm3-manager/models"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// schema delle release su Redis:
+//   release:<jwt>           hash con i campi di models.Release
+//   owner:<cf>:releases     set con i jwt delle release dell'utente
+//   delivered:releases      set con i jwt delle release consegnate
+//   release:<jwt>:reserved  id riservato da un caricamento non ancora salvato
+
+const deliveredIndexKey = "delivered:releases"
+
+func releaseKey(jwt string) string {
+	return "release:" + jwt
+}
+
+func ownerIndexKey(owner string) string {
+	return "owner:" + owner + ":releases"
+}
+
+func reservationKey(jwt string) string {
+	return releaseKey(jwt) + ":reserved"
+}
+
+func releaseToHash(rel *models.Release) map[string]interface{} {
+	createdAt := ""
+	if !rel.CreatedAt.IsZero() {
+		createdAt = rel.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return map[string]interface{}{
+		"jwt":       rel.Jwt,
+		"name":      rel.Name,
+		"namespace": rel.Namespace,
+		"owner":     rel.Owner,
+		"createdAt": createdAt,
+		"delivered": strconv.FormatBool(rel.Delivered),
+	}
+}
+
+func releaseFromHash(hash map[string]string) (*models.Release, error) {
+	rel := &models.Release{
+		Jwt:       hash["jwt"],
+		Name:      hash["name"],
+		Namespace: hash["namespace"],
+		Owner:     hash["owner"],
+		Delivered: hash["delivered"] == "true",
+	}
+	if hash["createdAt"] != "" {
+		createdAt, err := time.Parse(time.RFC3339Nano, hash["createdAt"])
+		if err != nil {
+			return nil, err
+		}
+		rel.CreatedAt = createdAt
+	}
+	return rel, nil
+}
+
+// SaveRelease salva la release e aggiorna gli indici in un'unica transazione
+func (c *Client) SaveRelease(ctx context.Context, rel *models.Release) error {
+	_, err := c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, releaseKey(rel.Jwt), releaseToHash(rel))
+		pipe.SAdd(ctx, ownerIndexKey(rel.Owner), rel.Jwt)
+		if rel.Delivered {
+			pipe.SAdd(ctx, deliveredIndexKey, rel.Jwt)
+		} else {
+			pipe.SRem(ctx, deliveredIndexKey, rel.Jwt)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("(SaveRelease)Could not save release: ", err)
+		return err
+	}
+	return nil
+}
+
+// ReserveReleaseID riserva l'id di una nuova release con SETNX, false se è già riservato da un
+// altro caricamento; la riserva scade dopo ttl se il caricamento non viene completato
+func (c *Client) ReserveReleaseID(ctx context.Context, jwt string, ttl time.Duration) (bool, error) {
+	reserved, err := c.redisClient.SetNX(ctx, reservationKey(jwt), "1", ttl).Result()
+	if err != nil {
+		log.Println("(ReserveReleaseID)Could not reserve release id: ", err)
+		return false, err
+	}
+	return reserved, nil
+}
+
+func (c *Client) FreeReleaseID(ctx context.Context, jwt string) error {
+	err := c.redisClient.Del(ctx, reservationKey(jwt)).Err()
+	if err != nil {
+		log.Println("(FreeReleaseID)Could not free release id: ", err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) GetRelease(ctx context.Context, jwt string) (*models.Release, error) {
+	hash, err := c.redisClient.HGetAll(ctx, releaseKey(jwt)).Result()
+	if err != nil {
+		log.Println("(GetRelease)Could not get release: ", err)
+		return nil, err
+	}
+	if len(hash) == 0 {
+		return nil, models.ErrReleaseNotFound
+	}
+	return releaseFromHash(hash)
+}
+
+func (c *Client) GetReleasesByOwner(ctx context.Context, owner string) ([]*models.Release, error) {
+	return c.getReleasesFromIndex(ctx, ownerIndexKey(owner))
+}
+
+// GetDeliveredReleases restituisce le release consegnate di tutti gli utenti
+func (c *Client) GetDeliveredReleases(ctx context.Context) ([]*models.Release, error) {
+	return c.getReleasesFromIndex(ctx, deliveredIndexKey)
+}
+
+// legge le release i cui jwt sono nel set indexKey
+func (c *Client) getReleasesFromIndex(ctx context.Context, indexKey string) ([]*models.Release, error) {
+	jwts, err := c.redisClient.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		log.Println("(getReleasesFromIndex)Could not get index", indexKey, err)
+		return nil, err
+	}
+	commands := make([]*redis.MapStringStringCmd, 0, len(jwts))
+	_, err = c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, jwt := range jwts {
+			commands = append(commands, pipe.HGetAll(ctx, releaseKey(jwt)))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("(getReleasesFromIndex)Could not get releases: ", err)
+		return nil, err
+	}
+	rels := make([]*models.Release, 0, len(commands))
+	for _, command := range commands {
+		if len(command.Val()) == 0 {
+			// indice non allineato, la release non esiste più
+			continue
+		}
+		rel, err := releaseFromHash(command.Val())
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, rel)
+	}
+	return rels, nil
+}
+
+func (c *Client) CountReleasesByOwner(ctx context.Context, owner string) (int64, error) {
+	val, err := c.redisClient.SCard(ctx, ownerIndexKey(owner)).Result()
+	if err != nil {
+		log.Println("(CountReleasesByOwner)Could not count releases: ", err)
+		return 0, err
+	}
+	return val, nil
+}
+
+func (c *Client) SetDelivered(ctx context.Context, jwt string, delivered bool) error {
+	exists, err := c.redisClient.Exists(ctx, releaseKey(jwt)).Result()
+	if err != nil {
+		log.Println("(SetDelivered)Could not check release: ", err)
+		return err
+	}
+	if exists == 0 {
+		return models.ErrReleaseNotFound
+	}
+	_, err = c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, releaseKey(jwt), "delivered", strconv.FormatBool(delivered))
+		if delivered {
+			pipe.SAdd(ctx, deliveredIndexKey, jwt)
+		} else {
+			pipe.SRem(ctx, deliveredIndexKey, jwt)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("(SetDelivered)Could not update release: ", err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) DeleteRelease(ctx context.Context, rel *models.Release) error {
+	_, err := c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, releaseKey(rel.Jwt))
+		pipe.SRem(ctx, ownerIndexKey(rel.Owner), rel.Jwt)
+		pipe.SRem(ctx, deliveredIndexKey, rel.Jwt)
+		return nil
+	})
+	if err != nil {
+		log.Println("(DeleteRelease)Could not delete release: ", err)
+		return err
+	}
+	return nil
+}
+
+// formato delle release salvate nei vecchi set rel-<cf> e rel-admin
+type legacyRelease struct {
+	Jwt       string `json:"jwt"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+const legacyAdminKey = "rel-admin"
+
+// legacyStore contiene le operazioni usate dalla migrazione dei vecchi set, implementate
+// sia su Redis che in memoria
+type legacyStore interface {
+	GetRelease(ctx context.Context, jwt string) (*models.Release, error)
+	SaveRelease(ctx context.Context, rel *models.Release) error
+	SetDelivered(ctx context.Context, jwt string, delivered bool) error
+	// chiavi dei set rel-<cf>, escluso rel-admin
+	legacyKeys(ctx context.Context) ([]string, error)
+	legacyMembers(ctx context.Context, key string) ([]string, error)
+	deleteLegacySet(ctx context.Context, key string) error
+}
+
+// MigrateLegacyReleases converte le release salvate come stringhe json nei set rel-<cf> nel nuovo
+// schema e segna come consegnate quelle presenti in rel-admin. Un set viene eliminato solo se
+// tutte le sue release sono state convertite, così la migrazione può essere ripetuta ad ogni avvio
+func (c *Client) MigrateLegacyReleases(ctx context.Context) (int, error) {
+	return migrateLegacyReleases(ctx, c)
+}
+
+func (c *Client) legacyKeys(ctx context.Context) ([]string, error) {
+	keys := make([]string, 0)
+	iter := c.redisClient.Scan(ctx, 0, "rel-*", 100).Iterator()
+	for iter.Next(ctx) {
+		if iter.Val() != legacyAdminKey {
+			keys = append(keys, iter.Val())
+		}
+	}
+	if err := iter.Err(); err != nil {
+		log.Println("(MigrateLegacyReleases)Could not scan keys: ", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (c *Client) legacyMembers(ctx context.Context, key string) ([]string, error) {
+	members, err := c.redisClient.SMembers(ctx, key).Result()
+	if err != nil {
+		log.Println("(MigrateLegacyReleases)Could not get set", key, err)
+		return nil, err
+	}
+	return members, nil
+}
+
+func (c *Client) deleteLegacySet(ctx context.Context, key string) error {
+	err := c.redisClient.Del(ctx, key).Err()
+	if err != nil {
+		log.Println("(MigrateLegacyReleases)Could not delete set", key, err)
+		return err
+	}
+	return nil
+}
+
+func migrateLegacyReleases(ctx context.Context, store legacyStore) (int, error) {
+	keys, err := store.legacyKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, key := range keys {
+		owner := strings.TrimPrefix(key, "rel-")
+		n, err := migrateLegacySet(ctx, store, key, func(legacy legacyRelease) error {
+			_, err := store.GetRelease(ctx, legacy.Jwt)
+			if err == nil {
+				// già convertita da una migrazione interrotta
+				return nil
+			}
+			if !errors.Is(err, models.ErrReleaseNotFound) {
+				return err
+			}
+			// la data di creazione delle vecchie release non è nota
+			return store.SaveRelease(ctx, &models.Release{
+				Jwt:       legacy.Jwt,
+				Name:      legacy.Name,
+				Namespace: legacy.Namespace,
+				Owner:     owner,
+			})
+		})
+		migrated += n
+		if err != nil {
+			return migrated, err
+		}
+	}
+	// rel-admin va convertito per ultimo, quando le release consegnate esistono già; le
+	// release consegnate restano visibili agli amministratori con GetDeliveredReleases
+	_, err = migrateLegacySet(ctx, store, legacyAdminKey, func(legacy legacyRelease) error {
+		err := store.SetDelivered(ctx, legacy.Jwt, true)
+		if errors.Is(err, models.ErrReleaseNotFound) {
+			return nil
+		}
+		return err
+	})
+	return migrated, err
+}
+
+// applica migrate ad ogni elemento del set ed elimina il set se non ci sono stati errori
+func migrateLegacySet(ctx context.Context, store legacyStore, key string, migrate func(legacyRelease) error) (int, error) {
+	members, err := store.legacyMembers(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	failed := false
+	for _, member := range members {
+		var legacy legacyRelease
+		err := json.Unmarshal([]byte(member), &legacy)
+		if err != nil || legacy.Jwt == "" {
+			// es. un nome con le virgolette, che produceva un json non valido
+			log.Println("(MigrateLegacyReleases)Skipping malformed release in", key, member)
+			failed = true
+			continue
+		}
+		err = migrate(legacy)
+		if err != nil {
+			log.Println("(MigrateLegacyReleases)Could not migrate release", legacy.Jwt, err)
+			return migrated, err
+		}
+		migrated++
+	}
+	if failed {
+		log.Println("(MigrateLegacyReleases)Keeping", key, "because some releases could not be migrated")
+		return migrated, nil
+	}
+	return migrated, store.deleteLegacySet(ctx, key)
+}
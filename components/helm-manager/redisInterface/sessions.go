@@ -0,0 +1,52 @@
+package redisInterface
+
+import (
+	"context"
+	"errors"
+	"helm3-manager/models"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// schema delle sessioni su Redis:
+//   <token>                 cf dell'utente, scritto dalla UI al login
+//   denylist:<jti>          token firmati revocati, con scadenza pari a quella del token
+
+func denylistKey(id string) string {
+	return "denylist:" + id
+}
+
+// GetSession restituisce il cf associato al token di sessione creato dalla UI,
+// models.ErrSessionNotFound se la sessione non esiste o è scaduta
+func (c *Client) GetSession(ctx context.Context, token string) (string, error) {
+	cf, err := c.redisClient.Get(ctx, token).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", models.ErrSessionNotFound
+	}
+	if err != nil {
+		log.Println("(GetSession)Could not get session: ", err)
+		return "", err
+	}
+	return cf, nil
+}
+
+// RevokeToken aggiunge il token alla denylist; la chiave scade insieme al token, dopo non serve più
+func (c *Client) RevokeToken(ctx context.Context, id string, ttl time.Duration) error {
+	err := c.redisClient.Set(ctx, denylistKey(id), "1", ttl).Err()
+	if err != nil {
+		log.Println("(RevokeToken)Could not revoke token: ", err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	val, err := c.redisClient.Exists(ctx, denylistKey(id)).Result()
+	if err != nil {
+		log.Println("(IsTokenRevoked)Could not check denylist: ", err)
+		return false, err
+	}
+	return val == 1, nil
+}
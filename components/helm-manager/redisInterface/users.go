@@ -0,0 +1,104 @@
+package redisInterface
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// schema degli utenti su Redis:
+//   user:<cf>               hash con il campo role
+//   user:<cf>:courses       set con i corsi dell'utente
+//   course:<name>:members   set con i cf di studenti e docenti del corso
+
+func userKey(cf string) string {
+	return "user:" + cf
+}
+
+func userCoursesKey(cf string) string {
+	return "user:" + cf + ":courses"
+}
+
+func courseMembersKey(course string) string {
+	return "course:" + course + ":members"
+}
+
+// GetUserRole restituisce il ruolo salvato per il cf, una stringa vuota se non è mai stato assegnato
+func (c *Client) GetUserRole(ctx context.Context, cf string) (string, error) {
+	role, err := c.redisClient.HGet(ctx, userKey(cf), "role").Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		log.Println("(GetUserRole)Could not get role: ", err)
+		return "", err
+	}
+	return role, nil
+}
+
+func (c *Client) SetUserRole(ctx context.Context, cf string, role string) error {
+	err := c.redisClient.HSet(ctx, userKey(cf), "role", role).Err()
+	if err != nil {
+		log.Println("(SetUserRole)Could not set role: ", err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) GetCourseMembers(ctx context.Context, course string) ([]string, error) {
+	members, err := c.redisClient.SMembers(ctx, courseMembersKey(course)).Result()
+	if err != nil {
+		log.Println("(GetCourseMembers)Could not get members: ", err)
+		return nil, err
+	}
+	return members, nil
+}
+
+// GetUserCourses restituisce i corsi di cui l'utente fa parte
+func (c *Client) GetUserCourses(ctx context.Context, cf string) ([]string, error) {
+	courses, err := c.redisClient.SMembers(ctx, userCoursesKey(cf)).Result()
+	if err != nil {
+		log.Println("(GetUserCourses)Could not get courses: ", err)
+		return nil, err
+	}
+	return courses, nil
+}
+
+// AddCourseMember aggiorna insieme il set del corso e quello dei corsi dell'utente
+func (c *Client) AddCourseMember(ctx context.Context, course string, cf string) error {
+	_, err := c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, courseMembersKey(course), cf)
+		pipe.SAdd(ctx, userCoursesKey(cf), course)
+		return nil
+	})
+	if err != nil {
+		log.Println("(AddCourseMember)Could not add member: ", err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) RemoveCourseMember(ctx context.Context, course string, cf string) error {
+	_, err := c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, courseMembersKey(course), cf)
+		pipe.SRem(ctx, userCoursesKey(cf), course)
+		return nil
+	})
+	if err != nil {
+		log.Println("(RemoveCourseMember)Could not remove member: ", err)
+		return err
+	}
+	return nil
+}
+
+// ShareCourse indica se i due utenti appartengono ad almeno un corso in comune
+func (c *Client) ShareCourse(ctx context.Context, cf string, other string) (bool, error) {
+	courses, err := c.redisClient.SInter(ctx, userCoursesKey(cf), userCoursesKey(other)).Result()
+	if err != nil {
+		log.Println("(ShareCourse)Could not intersect courses: ", err)
+		return false, err
+	}
+	return len(courses) > 0, nil
+}
@@ -0,0 +1,224 @@
+package relHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"helm3-manager/models"
+	"helm3-manager/tokenHandler"
+	"log"
+	"strings"
+	"time"
+)
+
+// Action è l'operazione richiesta su una release, usata per decidere se l'utente può eseguirla
+type Action string
+
+const (
+	// dettagli, cronologia, manifest ed elenco delle release
+	ActionView Action = "view"
+	// log, anche in streaming
+	ActionLogs Action = "logs"
+	ActionStop Action = "stop"
+	// installazione, aggiornamento, rollback, eliminazione, consegna e terminale
+	ActionManage Action = "manage"
+)
+
+// prefisso dei token firmati da helm-manager nell'header Authorization, i token senza prefisso
+// sono le sessioni create dalla UI e salvate su Redis
+const bearerPrefix = "Bearer "
+
+// Authenticate risolve l'utente a partire dal token, ErrUnauthorized se il token non è valido
+func (s *Service) Authenticate(ctx context.Context, token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrUnauthorized
+	}
+	if strings.HasPrefix(token, bearerPrefix) {
+		claims, err := s.verifyToken(ctx, strings.TrimPrefix(token, bearerPrefix))
+		if err != nil {
+			return nil, err
+		}
+		// il ruolo nel token può non essere più valido, ad esempio per un docente tornato studente
+		role, err := s.getRole(ctx, claims.Cf)
+		if err != nil {
+			return nil, err
+		}
+		return &models.User{Cf: claims.Cf, Role: role}, nil
+	}
+	cf, err := s.store.GetSession(ctx, token)
+	if errors.Is(err, models.ErrSessionNotFound) {
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		log.Println("Could not get session", err)
+		return nil, err
+	}
+	role, err := s.getRole(ctx, cf)
+	if err != nil {
+		return nil, err
+	}
+	return &models.User{Cf: cf, Role: role}, nil
+}
+
+// verifica firma e scadenza senza Redis, poi controlla che il token non sia stato revocato
+func (s *Service) verifyToken(ctx context.Context, token string) (*tokenHandler.Claims, error) {
+	if s.tokens == nil {
+		return nil, ErrUnauthorized
+	}
+	claims, err := s.tokens.Verify(token)
+	if err != nil {
+		log.Println("Invalid session token", err)
+		return nil, ErrUnauthorized
+	}
+	revoked, err := s.store.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		log.Println("Could not check token revocation", err)
+		return nil, err
+	}
+	if revoked {
+		log.Println("Revoked session token for", claims.Cf)
+		return nil, ErrUnauthorized
+	}
+	return claims, nil
+}
+
+// IssueToken firma un token per l'utente della sessione della UI indicata, con il ruolo letto
+// da Redis. Un token firmato non può essere usato per ottenerne un altro, altrimenti un token
+// potrebbe rinnovarsi all'infinito e revocarlo non revocherebbe quelli ottenuti con esso
+func (s *Service) IssueToken(ctx context.Context, token string) (string, time.Time, error) {
+	if s.tokens == nil {
+		return "", time.Time{}, ErrTokensDisabled
+	}
+	if strings.HasPrefix(token, bearerPrefix) {
+		log.Println("Signed token used to issue another token")
+		return "", time.Time{}, fmt.Errorf("%w: signed tokens can only be issued from a UI session", ErrForbidden)
+	}
+	user, err := s.Authenticate(ctx, token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signed, claims, err := s.tokens.Issue(user)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, claims.ExpiresAt.Time, nil
+}
+
+// RevokeToken aggiunge il token firmato alla denylist fino alla sua scadenza
+func (s *Service) RevokeToken(ctx context.Context, token string) error {
+	if !strings.HasPrefix(token, bearerPrefix) {
+		return fmt.Errorf("%w: only signed session tokens can be revoked", ErrInvalidRequest)
+	}
+	claims, err := s.verifyToken(ctx, strings.TrimPrefix(token, bearerPrefix))
+	if err != nil {
+		return err
+	}
+	return s.store.RevokeToken(ctx, claims.ID, time.Until(claims.ExpiresAt.Time))
+}
+
+// JWKS restituisce le chiavi pubbliche con cui verificare i token firmati
+func (s *Service) JWKS() []tokenHandler.JSONWebKey {
+	if s.tokens == nil {
+		return []tokenHandler.JSONWebKey{}
+	}
+	return s.tokens.JWKS()
+}
+
+// gli amministratori della configurazione non dipendono da Redis, così c'è sempre qualcuno
+// in grado di assegnare i ruoli; chi non ha un ruolo salvato è uno studente
+func (s *Service) getRole(ctx context.Context, cf string) (string, error) {
+	if s.conf.IsAdmin(cf) {
+		return models.RoleAdmin, nil
+	}
+	role, err := s.store.GetUserRole(ctx, cf)
+	if err != nil {
+		log.Println("Could not get user role", err)
+		return "", err
+	}
+	if !models.IsValidRole(role) {
+		return models.RoleStudent, nil
+	}
+	return role, nil
+}
+
+// AuthorizeRelease restituisce la release se l'utente può eseguire action, ErrReleaseNotFound
+// se non esiste ed ErrForbidden altrimenti
+func (s *Service) AuthorizeRelease(ctx context.Context, user *models.User, jwt string, action Action) (*models.Release, error) {
+	rel, err := s.store.GetRelease(ctx, jwt)
+	if errors.Is(err, ErrReleaseNotFound) {
+		log.Println("Release " + jwt + " not found")
+		return nil, err
+	}
+	if err != nil {
+		log.Println("Could not get release from Redis", err)
+		return nil, err
+	}
+	err = s.authorizeOwner(ctx, user, rel.Owner, action)
+	if err != nil {
+		log.Println("User " + user.Cf + " cannot " + string(action) + " release " + jwt)
+		return nil, err
+	}
+	return rel, nil
+}
+
+// lo studente opera solo sulle proprie release, il docente può anche consultare, leggere i log
+// e fermare le release degli utenti dei propri corsi, l'amministratore può fare tutto
+func (s *Service) authorizeOwner(ctx context.Context, user *models.User, owner string, action Action) error {
+	if owner == user.Cf || user.Role == models.RoleAdmin {
+		return nil
+	}
+	if user.Role != models.RoleTeacher || action == ActionManage {
+		return ErrForbidden
+	}
+	share, err := s.store.ShareCourse(ctx, user.Cf, owner)
+	if err != nil {
+		log.Println("Could not check courses", err)
+		return err
+	}
+	if !share {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// SetUserRole assegna il ruolo a un utente, solo per gli amministratori
+func (s *Service) SetUserRole(ctx context.Context, cf string, role string) error {
+	if cf == "" || !models.IsValidRole(role) {
+		return fmt.Errorf("%w: cf and a role among student, teacher and admin are required", ErrInvalidRequest)
+	}
+	return s.store.SetUserRole(ctx, cf, role)
+}
+
+// SetCourseMember aggiunge o rimuove un utente da un corso, solo per gli amministratori
+func (s *Service) SetCourseMember(ctx context.Context, course string, cf string, member bool) error {
+	if course == "" || cf == "" {
+		return fmt.Errorf("%w: course and cf are required", ErrInvalidRequest)
+	}
+	if member {
+		return s.store.AddCourseMember(ctx, course, cf)
+	}
+	return s.store.RemoveCourseMember(ctx, course, cf)
+}
+
+// GetCourseMembers restituisce i cf degli utenti del corso, ai docenti del corso e agli amministratori
+func (s *Service) GetCourseMembers(ctx context.Context, user *models.User, course string) ([]string, error) {
+	if course == "" {
+		return nil, fmt.Errorf("%w: course is required", ErrInvalidRequest)
+	}
+	members, err := s.store.GetCourseMembers(ctx, course)
+	if err != nil {
+		log.Println("Could not get course members", err)
+		return nil, err
+	}
+	if user.Role == models.RoleAdmin {
+		return members, nil
+	}
+	if user.Role == models.RoleTeacher {
+		for _, member := range members {
+			if member == user.Cf {
+				return members, nil
+			}
+		}
+	}
+	return nil, ErrForbidden
+}
@@ -0,0 +1,92 @@
+package relHandler
+
+import (
+	"context"
+	"errors"
+	"helm3-manager/config"
+	"helm3-manager/helmInterface"
+	"helm3-manager/models"
+	"helm3-manager/tokenHandler"
+	"testing"
+)
+
+func TestIssueToken(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// restituisce l'header Authorization con cui viene richiesto il token
+		authorization func(t *testing.T, f *fixture) string
+		// modifica i ruoli su Redis dopo l'emissione del token
+		after    func(f *fixture)
+		wantErr  error
+		wantRole string
+	}{
+		{
+			name:          "ui session",
+			authorization: func(t *testing.T, f *fixture) string { return "session" },
+			wantRole:      models.RoleTeacher,
+		},
+		{
+			name:          "role changed after issue",
+			authorization: func(t *testing.T, f *fixture) string { return "session" },
+			after: func(f *fixture) {
+				f.store.SetUserRole(ctx, "teacher1", models.RoleStudent)
+			},
+			wantRole: models.RoleStudent,
+		},
+		{
+			name: "signed token",
+			authorization: func(t *testing.T, f *fixture) string {
+				token, _, err := f.service.IssueToken(ctx, "session")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return bearerPrefix + token
+			},
+			wantErr: ErrForbidden,
+		},
+		{
+			name:          "unknown session",
+			authorization: func(t *testing.T, f *fixture) string { return "expired" },
+			wantErr:       ErrUnauthorized,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, helmInterface.NewMemoryDeployer())
+			tokens, err := tokenHandler.NewManager(config.SessionConfig{
+				Algorithm:     "HS256",
+				TTLSeconds:    3600,
+				MaxAgeSeconds: 7200,
+				HMACKey:       "0123456789abcdef0123456789abcdef",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.service.tokens = tokens
+			f.store.SetKeyValue("session", "teacher1")
+			f.store.SetUserRole(ctx, "teacher1", models.RoleTeacher)
+
+			token, _, err := f.service.IssueToken(ctx, test.authorization(t, f))
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("IssueToken() error = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.after != nil {
+				test.after(f)
+			}
+			user, err := f.service.Authenticate(ctx, bearerPrefix+token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Cf != "teacher1" || user.Role != test.wantRole {
+				t.Errorf("Authenticate() = %+v, want teacher1 with role %s", user, test.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package relHandler
+
+import (
+	"errors"
+	"fmt"
+	"helm3-manager/composeHandler"
+	"helm3-manager/helmInterface"
+	"helm3-manager/models"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+// campo del form di /upload con un docker-compose.yml da usare al posto del values.yaml
+const composeFormField = "composeFile"
+
+// IsComposeUpload indica se il caricamento contiene un docker-compose.yml invece del values.yaml;
+// un form che non può essere letto restituisce ErrInvalidRequest
+func (s *Service) IsComposeUpload(r *http.Request) (bool, error) {
+	err := r.ParseMultipartForm(s.conf.MaxValuesSize)
+	if err != nil {
+		log.Println("Could not parse upload form", err)
+		return false, fmt.Errorf("%w: %s", ErrInvalidRequest, err.Error())
+	}
+	return len(r.MultipartForm.File[composeFormField]) > 0, nil
+}
+
+// ComposeHandler converte il docker-compose.yml caricato nel values.yaml della release e
+// restituisce le parti ignorate o adattate. Va chiamata dopo ArchiveHandler perché gli env_file
+// e i bind mount vengono cercati tra i file caricati
+func (s *Service) ComposeHandler(r *http.Request, jwt string) ([]models.Warning, error) {
+	r.ParseMultipartForm(s.conf.MaxValuesSize)
+	file, handler, err := r.FormFile(composeFormField)
+	if err != nil {
+		log.Println("File not found")
+		return nil, fmt.Errorf("%w: docker-compose file is missing", ErrInvalidRequest)
+	}
+	defer file.Close()
+	extension := filepath.Ext(handler.Filename)
+	if extension != ".yml" && extension != ".yaml" {
+		log.Println("File is not a yaml")
+		return nil, fmt.Errorf("%w: file is not a yaml", ErrInvalidRequest)
+	}
+	if handler.Size > s.conf.MaxValuesSize {
+		return nil, fmt.Errorf("%w: docker-compose file exceeds the maximum size of %d bytes", ErrInvalidRequest, s.conf.MaxValuesSize)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Println("Could not read file content", err)
+		return nil, err
+	}
+	values, warnings, err := composeHandler.Convert(data, os.DirFS(filepath.Join(s.releaseDir(jwt), "mnt")))
+	if errors.Is(err, composeHandler.ErrInvalidCompose) {
+		log.Println("Could not parse docker-compose file", err)
+		return nil, fmt.Errorf("%w: %s", ErrInvalidValues, err.Error())
+	}
+	if err != nil {
+		log.Println("Could not convert docker-compose file", err)
+		return nil, err
+	}
+	valuesData, err := yaml.Marshal(values)
+	if err != nil {
+		log.Println("Could not marshal values", err)
+		return nil, err
+	}
+	// i values generati passano dalla stessa validazione di quelli caricati
+	fieldErrors, err := helmInterface.ValidateValues(valuesData)
+	if err != nil {
+		log.Println("Could not validate values file", err)
+		return nil, err
+	}
+	if len(fieldErrors) > 0 {
+		log.Println("Converted values do not match the schema")
+		return nil, &models.ValidationError{Errors: fieldErrors}
+	}
+	err = os.WriteFile(s.valuesPath(jwt), valuesData, 0666)
+	if err != nil {
+		log.Println("Could not create file", err)
+		return nil, err
+	}
+	return warnings, nil
+}
@@ -0,0 +1,56 @@
+package relHandler
+
+import (
+	"bytes"
+	"errors"
+	"helm3-manager/helmInterface"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsComposeUpload(t *testing.T) {
+	tests := []struct {
+		name string
+		// campo del form con il file caricato, vuoto per inviare body con contentType
+		field       string
+		contentType string
+		body        string
+		want        bool
+		wantErr     error
+	}{
+		{name: "compose file", field: composeFormField, want: true},
+		{name: "values file", field: "yamlFile"},
+		{name: "not multipart", contentType: "application/json", body: "{}", wantErr: ErrInvalidRequest},
+		{name: "truncated form", contentType: "multipart/form-data; boundary=x", body: "--x\r\nContent-Disposition: form-data; name=\"name\"\r\n\r\nshop", wantErr: ErrInvalidRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, helmInterface.NewMemoryDeployer())
+			var body io.Reader = strings.NewReader(test.body)
+			contentType := test.contentType
+			if test.field != "" {
+				buffer := &bytes.Buffer{}
+				form := multipart.NewWriter(buffer)
+				part, err := form.CreateFormFile(test.field, "upload.yml")
+				if err != nil {
+					t.Fatal(err)
+				}
+				part.Write([]byte("services: {}\n"))
+				form.Close()
+				body, contentType = buffer, form.FormDataContentType()
+			}
+			r := httptest.NewRequest("POST", "/upload", body)
+			r.Header.Set("Content-Type", contentType)
+			got, err := f.service.IsComposeUpload(r)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("IsComposeUpload() error = %v, want %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("IsComposeUpload() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package relHandler
+
+import (
+	"errors"
+	"helm3-manager/models"
+)
+
+// errori restituiti dal Service, httpHandler li traduce nel codice HTTP corrispondente.
+// Gli errori vanno confrontati con errors.Is perché possono essere arricchiti con fmt.Errorf("%w")
+var (
+	ErrUnauthorized    = errors.New("unauthorized request")
+	ErrForbidden       = errors.New("forbidden request")
+	ErrReleaseNotFound = models.ErrReleaseNotFound
+	ErrReleaseActive   = errors.New("release already active")
+	ErrReleaseInactive = errors.New("release not active")
+	ErrQuotaExceeded   = errors.New("quota exceeded")
+	ErrInvalidRequest  = errors.New("invalid request")
+	ErrInvalidValues   = errors.New("invalid values")
+	ErrTokensDisabled  = errors.New("signed session tokens are not configured")
+)
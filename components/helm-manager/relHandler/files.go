@@ -0,0 +1,310 @@
+package relHandler
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"helm3-manager/helmInterface"
+	"helm3-manager/k8sInterface"
+	"helm3-manager/models"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// i file caricati arrivano ai pod senza montare cartelle del nodo: i file piccoli indicati dai
+// volumi con file diventano chiavi di una ConfigMap (o di un Secret con secret: true), gli
+// elementi di files diventano ConfigMap o Secret con nome files-<name>; le cartelle e i file
+// più grandi vengono copiati da un init container nella claim filesClaimName, scaricandoli
+// da helm-manager con il token della release
+const (
+	filesClaimName      = helmInterface.FilesVolumeName
+	uploadedFilesName   = "packs-uploaded-files"
+	uploadedSecretsName = "packs-uploaded-secrets"
+	userFilesPrefix     = "files-"
+	// una ConfigMap non può superare 1MiB, i file oltre questo totale vanno nella claim
+	maxInlineTotal = 768 << 10
+)
+
+var invalidKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// releaseFiles contiene le ConfigMap e i Secret da creare per una release e i dati per il template
+type releaseFiles struct {
+	objects []k8sInterface.FilesObject
+	// percorso del file nell'archivio -> chiave nella ConfigMap o nel Secret
+	uploaded   map[string]interface{}
+	needsClaim bool
+	checksum   string
+}
+
+// cleanUploadPath rende relativo alla cartella dei file caricati il percorso indicato in un volume;
+// i percorsi che escono dalla cartella vengono rifiutati
+func cleanUploadPath(p string) (string, error) {
+	for _, element := range strings.Split(filepath.ToSlash(p), "/") {
+		if element == ".." {
+			return "", fmt.Errorf("%w: path %q must not contain ..", ErrInvalidValues, p)
+		}
+	}
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/"), nil
+}
+
+// la chiave deve essere valida per una ConfigMap, l'hash distingue file con lo stesso nome
+func uploadedFileKey(p string) string {
+	sum := sha256.Sum256([]byte(p))
+	return hex.EncodeToString(sum[:4]) + "-" + invalidKeyChars.ReplaceAllString(path.Base(p), "_")
+}
+
+// planFiles decide come montare i file di ogni volume e sostituisce nei values i percorsi
+// dei volumi con quelli puliti, usati dal template per i subPath
+func (s *Service) planFiles(rel *models.Release, values map[string]interface{}) (*releaseFiles, error) {
+	mntDir := filepath.Join(s.releaseDir(rel.Jwt), "mnt")
+	files := &releaseFiles{uploaded: make(map[string]interface{})}
+	configData := make(map[string][]byte)
+	secretData := make(map[string][]byte)
+	var inlineTotal int64
+	components, _ := values["components"].([]interface{})
+	for _, item := range components {
+		component, _ := item.(map[string]interface{})
+		volumes, _ := component["volumes"].([]interface{})
+		for _, v := range volumes {
+			volume, _ := v.(map[string]interface{})
+			if directory, ok := volume["directory"].(string); ok {
+				cleaned, err := cleanUploadPath(directory)
+				if err != nil {
+					return nil, err
+				}
+				volume["directory"] = cleaned
+				files.needsClaim = true
+			}
+			file, ok := volume["file"].(string)
+			if !ok {
+				continue
+			}
+			cleaned, err := cleanUploadPath(file)
+			if err != nil {
+				return nil, err
+			}
+			volume["file"] = cleaned
+			info, err := os.Lstat(filepath.Join(mntDir, cleaned))
+			if err != nil || !info.Mode().IsRegular() {
+				return nil, fmt.Errorf("%w: file %q not found in the uploaded archive", ErrInvalidValues, file)
+			}
+			key := uploadedFileKey(cleaned)
+			_, inConfig := configData[key]
+			_, inSecret := secretData[key]
+			if !inConfig && !inSecret {
+				if info.Size() > s.conf.Files.InlineMaxFileSize || inlineTotal+info.Size() > maxInlineTotal {
+					files.needsClaim = true
+					continue
+				}
+				inlineTotal += info.Size()
+			}
+			data, err := os.ReadFile(filepath.Join(mntDir, cleaned))
+			if err != nil {
+				log.Println("Could not read uploaded file", err)
+				return nil, err
+			}
+			if volume["secret"] == true {
+				secretData[key] = data
+			} else {
+				configData[key] = data
+			}
+			files.uploaded[cleaned] = key
+		}
+	}
+	if len(configData) > 0 {
+		files.objects = append(files.objects, k8sInterface.FilesObject{Name: uploadedFilesName, Data: configData})
+	}
+	if len(secretData) > 0 {
+		files.objects = append(files.objects, k8sInterface.FilesObject{Name: uploadedSecretsName, Secret: true, Data: secretData})
+	}
+	entries, _ := values["files"].([]interface{})
+	for _, item := range entries {
+		entry, _ := item.(map[string]interface{})
+		data := make(map[string][]byte)
+		content, _ := entry["data"].(map[string]interface{})
+		for key, value := range content {
+			data[key] = []byte(fmt.Sprint(value))
+		}
+		files.objects = append(files.objects, k8sInterface.FilesObject{
+			Name:   userFilesPrefix + fmt.Sprint(entry["name"]),
+			Secret: entry["secret"] == true,
+			Data:   data,
+		})
+	}
+	if files.needsClaim {
+		checksum, err := s.filesChecksum(rel.Jwt)
+		if err != nil {
+			return nil, err
+		}
+		files.checksum = checksum
+	}
+	return files, nil
+}
+
+// il checksum dell'archivio cambia il pod template ad ogni nuovo caricamento, così gli
+// init container copiano di nuovo i file
+func (s *Service) filesChecksum(jwt string) (string, error) {
+	archive, err := os.Open(s.archivePath(jwt))
+	if os.IsNotExist(err) {
+		return "none", nil
+	}
+	if err != nil {
+		log.Println("Could not open archive", err)
+		return "", err
+	}
+	defer archive.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, archive)
+	if err != nil {
+		log.Println("Could not read archive", err)
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// prepareFiles aggiunge ai values la chiave packs usata dal template per i volumi e per gli init
+// container; con apply crea anche ConfigMap, Secret e claim nel namespace, altrimenti serve
+// solo per il render
+func (s *Service) prepareFiles(ctx context.Context, rel *models.Release, values map[string]interface{}, apply bool) error {
+	files, err := s.planFiles(rel, values)
+	if err != nil {
+		return err
+	}
+	packs := map[string]interface{}{
+		"uploadedFiles":     files.uploaded,
+		"uploadedConfigMap": uploadedFilesName,
+		"uploadedSecret":    uploadedSecretsName,
+		"filesPrefix":       userFilesPrefix,
+		"waitImage":         s.conf.WaitImage,
+	}
+	if files.needsClaim {
+		packs["filesClaim"] = filesClaimName
+		packs["filesUrl"] = strings.TrimSuffix(s.conf.Files.BaseURL, "/") + "/files?release=" + rel.Jwt
+		packs["filesChecksum"] = files.checksum
+		packs["filesInitImage"] = s.conf.Files.InitImage
+	}
+	values["packs"] = packs
+	if !apply {
+		return nil
+	}
+	err = s.cluster.ApplyFiles(ctx, rel.Namespace, files.objects)
+	if err != nil {
+		log.Println("Could not apply files", err)
+		return err
+	}
+	if !files.needsClaim {
+		return nil
+	}
+	err = s.cluster.EnsureFilesClaim(ctx, rel.Namespace, filesClaimName, s.conf.Files)
+	if err != nil {
+		log.Println("Could not create files claim", err)
+		return err
+	}
+	token, err := s.filesToken(ctx, rel.Jwt)
+	if err != nil {
+		return err
+	}
+	packs["filesToken"] = token
+	return nil
+}
+
+// il token resta lo stesso finché la release è attiva, così un upgrade non cambia i pod
+// se non cambiano i file
+func (s *Service) filesToken(ctx context.Context, jwt string) (string, error) {
+	token, err := s.store.GetFilesToken(ctx, jwt)
+	if err != nil {
+		log.Println("Could not get files token", err)
+		return "", err
+	}
+	if token != "" {
+		return token, nil
+	}
+	id := make([]byte, 32)
+	_, err = rand.Read(id)
+	if err != nil {
+		log.Println("Could not generate files token", err)
+		return "", err
+	}
+	token = hex.EncodeToString(id)
+	err = s.store.SetFilesToken(ctx, jwt, token)
+	if err != nil {
+		log.Println("Could not save files token", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// CheckFilesToken restituisce ErrUnauthorized se il token non è quello della release attiva
+func (s *Service) CheckFilesToken(ctx context.Context, jwt string, token string) error {
+	expected, err := s.store.GetFilesToken(ctx, jwt)
+	if err != nil {
+		log.Println("Could not get files token", err)
+		return err
+	}
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+		log.Println("Invalid files token for release", jwt)
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// WriteReleaseFiles scrive in w un archivio tar con i file caricati della release; va chiamata
+// dopo CheckFilesToken. I link simbolici non vengono inclusi
+func (s *Service) WriteReleaseFiles(jwt string, w io.Writer) error {
+	mntDir := filepath.Join(s.releaseDir(jwt), "mnt")
+	archive := tar.NewWriter(w)
+	err := filepath.WalkDir(mntDir, func(p string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == mntDir {
+			// la release non ha un archivio, l'init container crea una cartella vuota
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if p == mntDir || !(entry.IsDir() || entry.Type().IsRegular()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(mntDir, p)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if entry.IsDir() {
+			header.Name += "/"
+		}
+		err = archive.WriteHeader(header)
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(archive, file)
+		return err
+	})
+	if err != nil {
+		log.Println("Could not write release files", err)
+		return err
+	}
+	return archive.Close()
+}
@@ -0,0 +1,141 @@
+package relHandler
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// gli id delle release sono usati come nome della release Helm (al massimo 53 caratteri) e,
+// con namespacePrefix davanti, come nome del namespace (al massimo 63 caratteri); entrambi
+// devono essere etichette DNS-1123: ^[a-z0-9]([-a-z0-9]*[a-z0-9])?$
+const (
+	namespacePrefix = "packs-"
+	maxIDPrefixLen  = 40
+	idSuffixLen     = 5
+	idSuffixChars   = "abcdefghijklmnopqrstuvwxyz0123456789"
+	// tentativi prima di rinunciare, con 36^5 suffissi per prefisso una collisione è già improbabile
+	maxIDAttempts = 10
+	defaultPrefix = "release"
+	// durata della riserva di un id, più lunga di qualsiasi caricamento
+	idReservationTTL = time.Hour
+)
+
+var errNoFreeID = errors.New("could not generate a free release id")
+
+// idPrefix ricava dal nome scelto dall'utente la parte leggibile dell'id: lettere minuscole e
+// cifre, gli altri caratteri diventano un singolo trattino
+func idPrefix(name string) string {
+	var builder strings.Builder
+	dash := false
+	for _, char := range strings.ToLower(name) {
+		if (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') {
+			if dash && builder.Len() > 0 {
+				builder.WriteByte('-')
+			}
+			builder.WriteRune(char)
+			dash = false
+		} else {
+			dash = true
+		}
+		if builder.Len() >= maxIDPrefixLen {
+			break
+		}
+	}
+	prefix := strings.Trim(builder.String(), "-")
+	if prefix == "" {
+		return defaultPrefix
+	}
+	return prefix
+}
+
+func idSuffix() (string, error) {
+	suffix := make([]byte, idSuffixLen)
+	max := big.NewInt(int64(len(idSuffixChars)))
+	for i := range suffix {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		suffix[i] = idSuffixChars[n.Int64()]
+	}
+	return string(suffix), nil
+}
+
+// releaseNamespace restituisce il namespace in cui viene installata la release con l'id indicato
+func releaseNamespace(id string) string {
+	return namespacePrefix + id
+}
+
+// NewReleaseID genera e riserva un id come webshop-x7k2q per una nuova release, scartando gli id
+// riservati da un altro caricamento, quelli già usati da una release su Redis e quelli il cui
+// namespace esiste già nel cluster. La riserva viene presa prima dei controlli, così due
+// caricamenti contemporanei non possono ottenere lo stesso id; va liberata con FreeReleaseID se
+// il caricamento fallisce, SaveToRedis la libera quando la release è salvata
+func (s *Service) NewReleaseID(ctx context.Context, name string) (string, error) {
+	prefix := idPrefix(name)
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		suffix, err := idSuffix()
+		if err != nil {
+			log.Println("Could not generate release id", err)
+			return "", err
+		}
+		id := prefix + "-" + suffix
+		reserved, err := s.store.ReserveReleaseID(ctx, id, idReservationTTL)
+		if err != nil {
+			log.Println("Could not reserve release id", err)
+			return "", err
+		}
+		if !reserved {
+			log.Println("Release id", id, "already reserved")
+			continue
+		}
+		free, err := s.isReleaseIDFree(ctx, id)
+		if err == nil && free {
+			return id, nil
+		}
+		s.FreeReleaseID(ctx, id)
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w after %d attempts", errNoFreeID, maxIDAttempts)
+}
+
+// isReleaseIDFree controlla che l'id non sia usato da una release su Redis o da un namespace
+func (s *Service) isReleaseIDFree(ctx context.Context, id string) (bool, error) {
+	_, err := s.store.GetRelease(ctx, id)
+	if err == nil {
+		log.Println("Release id", id, "already used")
+		return false, nil
+	}
+	if !errors.Is(err, ErrReleaseNotFound) {
+		log.Println("Could not check release id", err)
+		return false, err
+	}
+	exists, err := s.cluster.NamespaceExists(ctx, releaseNamespace(id))
+	if err != nil {
+		log.Println("Could not check namespace", err)
+		return false, err
+	}
+	if exists {
+		log.Println("Namespace for release id", id, "already exists")
+		return false, nil
+	}
+	return true, nil
+}
+
+// FreeReleaseID libera la riserva presa da NewReleaseID
+func (s *Service) FreeReleaseID(ctx context.Context, id string) error {
+	err := s.store.FreeReleaseID(ctx, id)
+	if err != nil {
+		log.Println("Could not free release id", err)
+		return err
+	}
+	return nil
+}
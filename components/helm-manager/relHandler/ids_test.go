@@ -0,0 +1,74 @@
+package relHandler
+
+import (
+	"context"
+	"helm3-manager/helmInterface"
+	"helm3-manager/models"
+	"strings"
+	"testing"
+)
+
+func TestNewReleaseIDReservation(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// completa o annulla il caricamento che ha ottenuto l'id
+		finish func(f *fixture, id string) error
+		// se l'id può essere riservato di nuovo da un altro caricamento
+		wantReservable bool
+		// se l'id è ancora libero su Redis e nel cluster
+		wantFree bool
+	}{
+		{
+			name:     "upload in progress",
+			finish:   func(f *fixture, id string) error { return nil },
+			wantFree: true,
+		},
+		{
+			name: "upload failed",
+			finish: func(f *fixture, id string) error {
+				return f.service.FreeReleaseID(ctx, id)
+			},
+			wantReservable: true,
+			wantFree:       true,
+		},
+		{
+			// l'id resta occupato dalla release, NewReleaseID la trova su Redis dopo la riserva
+			name: "upload saved",
+			finish: func(f *fixture, id string) error {
+				return f.service.SaveToRedis(ctx, id, "shop", &models.User{Cf: testOwner})
+			},
+			wantReservable: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, helmInterface.NewMemoryDeployer())
+			id, err := f.service.NewReleaseID(ctx, "Shop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(id, "shop-") {
+				t.Errorf("id = %q, want prefix shop-", id)
+			}
+			err = test.finish(f, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reservable, err := f.store.ReserveReleaseID(ctx, id, idReservationTTL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reservable != test.wantReservable {
+				t.Errorf("ReserveReleaseID() = %v, want %v", reservable, test.wantReservable)
+			}
+			free, err := f.service.isReleaseIDFree(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if free != test.wantFree {
+				t.Errorf("isReleaseIDFree() = %v, want %v", free, test.wantFree)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package relHandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"helm3-manager/helmInterface"
+	"helm3-manager/k8sInterface"
+	"helm3-manager/models"
+	"log"
+
+	v1n "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// resources sono le risorse richieste da una release attiva, calcolate dai manifest generati
+// con i suoi values; replicas conta tutti i pod, compresi quelli dei job
+type resources struct {
+	replicas int
+	cpu      resource.Quantity
+	memory   resource.Quantity
+}
+
+func (r *resources) add(other resources) {
+	r.replicas += other.replicas
+	r.cpu.Add(other.cpu)
+	r.memory.Add(other.memory)
+}
+
+// releaseResources somma le request dei pod che la release crea con i values indicati, a partire
+// dai manifest generati dal template, così vengono contati anche gli init container e i job.
+// values riceve la chiave packs di prepareFiles, che decide quali init container vengono creati
+func (s *Service) releaseResources(ctx context.Context, rel *models.Release, values map[string]interface{}) (resources, error) {
+	var total resources
+	defaults, err := s.defaultRequests()
+	if err != nil {
+		return total, err
+	}
+	chart, err := helmInterface.CreateChart(rel.Jwt)
+	if err != nil {
+		log.Println("Could not create chart", err)
+		return total, err
+	}
+	err = s.prepareFiles(ctx, rel, values, false)
+	if err != nil {
+		log.Println("Could not prepare files", err)
+		return total, err
+	}
+	rendered, err := helmInterface.Render(chart, values, rel.Jwt, rel.Namespace)
+	if err != nil {
+		log.Println("Could not render release", err)
+		return total, fmt.Errorf("%w: %s", ErrInvalidValues, err.Error())
+	}
+	templates, err := k8sInterface.PodTemplates(helmInterface.SplitManifests(rendered.Manifest)...)
+	if err != nil {
+		log.Println("Could not decode manifests", err)
+		return total, fmt.Errorf("%w: %s", ErrInvalidValues, err.Error())
+	}
+	for _, template := range templates {
+		pod := podResources(template.Spec, defaults)
+		for i := 0; i < template.Replicas; i++ {
+			total.add(pod)
+		}
+	}
+	return total, nil
+}
+
+// request di default della LimitRange dei namespace, assegnate ai container che non le dichiarano
+func (s *Service) defaultRequests() (resources, error) {
+	var defaults resources
+	cpu, err := resource.ParseQuantity(s.conf.NamespacePolicy.DefaultCPURequest)
+	if err != nil {
+		return defaults, err
+	}
+	memory, err := resource.ParseQuantity(s.conf.NamespacePolicy.DefaultMemoryRequest)
+	if err != nil {
+		return defaults, err
+	}
+	defaults.cpu, defaults.memory = cpu, memory
+	return defaults, nil
+}
+
+// podResources calcola le request di un pod come Kubernetes: la somma dei container o, se più
+// grande, la request di un singolo init container, che vengono eseguiti uno alla volta
+func podResources(spec v1n.PodSpec, defaults resources) resources {
+	pod := resources{replicas: 1}
+	for _, container := range spec.Containers {
+		cpu, memory := containerRequests(container, defaults)
+		pod.cpu.Add(cpu)
+		pod.memory.Add(memory)
+	}
+	for _, container := range spec.InitContainers {
+		cpu, memory := containerRequests(container, defaults)
+		if cpu.Cmp(pod.cpu) > 0 {
+			pod.cpu = cpu
+		}
+		if memory.Cmp(pod.memory) > 0 {
+			pod.memory = memory
+		}
+	}
+	return pod
+}
+
+func containerRequests(container v1n.Container, defaults resources) (resource.Quantity, resource.Quantity) {
+	return containerRequest(container, v1n.ResourceCPU, defaults.cpu), containerRequest(container, v1n.ResourceMemory, defaults.memory)
+}
+
+// come in Kubernetes, una risorsa con il solo limite ha la request uguale al limite
+func containerRequest(container v1n.Container, name v1n.ResourceName, defaultValue resource.Quantity) resource.Quantity {
+	if quantity, ok := container.Resources.Requests[name]; ok {
+		return quantity.DeepCopy()
+	}
+	if quantity, ok := container.Resources.Limits[name]; ok {
+		return quantity.DeepCopy()
+	}
+	return defaultValue.DeepCopy()
+}
+
+// usage è l'utilizzo delle release di un utente, escludendo la release indicata da exclude
+type usage struct {
+	releases       int
+	activeReleases int
+	resources
+}
+
+func (s *Service) quotaUsage(ctx context.Context, owner string, exclude string) (*usage, error) {
+	rels, err := s.store.GetReleasesByOwner(ctx, owner)
+	if err != nil {
+		log.Println("Could not get releases from Redis", err)
+		return nil, err
+	}
+	current := &usage{releases: len(rels)}
+	for _, rel := range rels {
+		if rel.Jwt == exclude {
+			continue
+		}
+		active, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
+		if err != nil {
+			log.Println("Could not check if release is active", err)
+			return nil, err
+		}
+		if !active {
+			continue
+		}
+		current.activeReleases++
+		values, err := s.getValuesMapFromToken(rel.Jwt)
+		if err != nil {
+			log.Println("Could not get values of release", rel.Jwt, err)
+			return nil, err
+		}
+		used, err := s.releaseResources(ctx, rel, values)
+		if err != nil {
+			log.Println("Could not compute resources", err)
+			return nil, err
+		}
+		current.add(used)
+	}
+	return current, nil
+}
+
+func (s *Service) defaultQuota() models.Quota {
+	return models.Quota{
+		MaxReleases:       s.conf.MaxReleasePerUser,
+		MaxActiveReleases: s.conf.MaxActiveReleasesPerUser,
+		MaxReplicas:       s.conf.MaxReplicasPerUser,
+		MaxCPU:            s.conf.MaxCPUPerUser,
+		MaxMemory:         s.conf.MaxMemoryPerUser,
+	}
+}
+
+// effectiveQuota calcola la quota dell'utente: i limiti impostati per l'utente valgono sempre,
+// gli altri sono i più alti tra quelli dei suoi corsi o, in mancanza, quelli di default
+func (s *Service) effectiveQuota(ctx context.Context, cf string) (*models.Quota, error) {
+	quota, err := s.store.GetUserQuota(ctx, cf)
+	if err != nil {
+		log.Println("Could not get user quota", err)
+		return nil, err
+	}
+	courses, err := s.store.GetUserCourses(ctx, cf)
+	if err != nil {
+		log.Println("Could not get user courses", err)
+		return nil, err
+	}
+	var courseQuota models.Quota
+	for _, course := range courses {
+		quota, err := s.store.GetCourseQuota(ctx, course)
+		if err != nil {
+			log.Println("Could not get course quota", err)
+			return nil, err
+		}
+		courseQuota = maxQuota(courseQuota, *quota)
+	}
+	fillQuota(quota, courseQuota)
+	fillQuota(quota, s.defaultQuota())
+	return quota, nil
+}
+
+// fillQuota copia in quota i limiti di from che in quota non sono impostati
+func fillQuota(quota *models.Quota, from models.Quota) {
+	if quota.MaxReleases == 0 {
+		quota.MaxReleases = from.MaxReleases
+	}
+	if quota.MaxActiveReleases == 0 {
+		quota.MaxActiveReleases = from.MaxActiveReleases
+	}
+	if quota.MaxReplicas == 0 {
+		quota.MaxReplicas = from.MaxReplicas
+	}
+	if quota.MaxCPU == "" {
+		quota.MaxCPU = from.MaxCPU
+	}
+	if quota.MaxMemory == "" {
+		quota.MaxMemory = from.MaxMemory
+	}
+}
+
+// maxQuota restituisce per ogni campo il limite più alto tra quelli impostati
+func maxQuota(a models.Quota, b models.Quota) models.Quota {
+	return models.Quota{
+		MaxReleases:       max(a.MaxReleases, b.MaxReleases),
+		MaxActiveReleases: max(a.MaxActiveReleases, b.MaxActiveReleases),
+		MaxReplicas:       max(a.MaxReplicas, b.MaxReplicas),
+		MaxCPU:            maxQuantity(a.MaxCPU, b.MaxCPU),
+		MaxMemory:         maxQuantity(a.MaxMemory, b.MaxMemory),
+	}
+}
+
+func maxQuantity(a string, b string) string {
+	first, err := resource.ParseQuantity(a)
+	if err != nil {
+		return b
+	}
+	second, err := resource.ParseQuantity(b)
+	if err != nil || first.Cmp(second) >= 0 {
+		return a
+	}
+	return b
+}
+
+// exceeds indica se used supera il limite, un limite non valido non viene applicato
+func exceeds(used resource.Quantity, limit string) bool {
+	quantity, err := resource.ParseQuantity(limit)
+	if err != nil {
+		log.Println("Invalid quota", limit, err)
+		return false
+	}
+	return used.Cmp(quantity) > 0
+}
+
+// checkResourceQuota controlla che la release possa essere attiva con i values indicati insieme
+// alle altre release attive del proprietario; la quota è quella del proprietario anche quando
+// l'operazione è fatta da un docente o da un amministratore
+func (s *Service) checkResourceQuota(ctx context.Context, rel *models.Release, values map[string]interface{}) error {
+	quota, err := s.effectiveQuota(ctx, rel.Owner)
+	if err != nil {
+		return err
+	}
+	current, err := s.quotaUsage(ctx, rel.Owner, rel.Jwt)
+	if err != nil {
+		return err
+	}
+	requested, err := s.releaseResources(ctx, rel, values)
+	if err != nil {
+		log.Println("Could not compute resources", err)
+		return err
+	}
+	current.activeReleases++
+	current.add(requested)
+	switch {
+	case current.activeReleases > quota.MaxActiveReleases:
+		return fmt.Errorf("%w: at most %d active releases allowed", ErrQuotaExceeded, quota.MaxActiveReleases)
+	case current.replicas > quota.MaxReplicas:
+		return fmt.Errorf("%w: %d replicas requested, at most %d allowed", ErrQuotaExceeded, current.replicas, quota.MaxReplicas)
+	case exceeds(current.cpu, quota.MaxCPU):
+		return fmt.Errorf("%w: %s cpu requested, at most %s allowed", ErrQuotaExceeded, current.cpu.String(), quota.MaxCPU)
+	case exceeds(current.memory, quota.MaxMemory):
+		return fmt.Errorf("%w: %s memory requested, at most %s allowed", ErrQuotaExceeded, current.memory.String(), quota.MaxMemory)
+	}
+	return nil
+}
+
+// CheckReleaseQuota restituisce ErrQuotaExceeded se l'utente ha già il numero massimo di release
+func (s *Service) CheckReleaseQuota(ctx context.Context, user *models.User) error {
+	quota, err := s.effectiveQuota(ctx, user.Cf)
+	if err != nil {
+		return err
+	}
+	n, err := s.store.CountReleasesByOwner(ctx, user.Cf)
+	if err != nil {
+		log.Println("Could not get number of release", err)
+		return err
+	}
+	if int(n) >= quota.MaxReleases {
+		log.Println("User " + user.Cf + " exceeded the number of releases")
+		return fmt.Errorf("%w: at most %d releases allowed", ErrQuotaExceeded, quota.MaxReleases)
+	}
+	return nil
+}
+
+// GetQuota restituisce la quota in vigore e l'utilizzo corrente di owner, o dell'utente stesso
+// se owner è vuoto; le quote di altri utenti sono visibili a chi può vederne le release
+func (s *Service) GetQuota(ctx context.Context, user *models.User, owner string) (string, error) {
+	if owner == "" {
+		owner = user.Cf
+	}
+	err := s.authorizeOwner(ctx, user, owner, ActionView)
+	if err != nil {
+		return "", err
+	}
+	quota, err := s.effectiveQuota(ctx, owner)
+	if err != nil {
+		return "", err
+	}
+	current, err := s.quotaUsage(ctx, owner, "")
+	if err != nil {
+		return "", err
+	}
+	json_bytes, err := json.Marshal(map[string]interface{}{
+		"quota": quota,
+		"usage": models.QuotaUsage{
+			Releases:       current.releases,
+			ActiveReleases: current.activeReleases,
+			Replicas:       current.replicas,
+			CPU:            current.cpu.String(),
+			Memory:         current.memory.String(),
+		},
+	})
+	if err != nil {
+		log.Println("Could not marshal quota", err)
+		return "", err
+	}
+	return string(json_bytes), nil
+}
+
+// GetStoredQuota restituisce la quota salvata per l'utente cf o per il corso course,
+// senza i limiti ereditati
+func (s *Service) GetStoredQuota(ctx context.Context, cf string, course string) (string, error) {
+	var quota *models.Quota
+	var err error
+	switch {
+	case cf != "" && course == "":
+		quota, err = s.store.GetUserQuota(ctx, cf)
+	case course != "" && cf == "":
+		quota, err = s.store.GetCourseQuota(ctx, course)
+	default:
+		return "", fmt.Errorf("%w: exactly one of cf and course is required", ErrInvalidRequest)
+	}
+	if err != nil {
+		log.Println("Could not get quota", err)
+		return "", err
+	}
+	json_bytes, err := json.Marshal(quota)
+	if err != nil {
+		log.Println("Could not marshal quota", err)
+		return "", err
+	}
+	return string(json_bytes), nil
+}
+
+// SetQuota sostituisce la quota dell'utente cf o del corso course; i campi non impostati
+// tornano ad essere ereditati
+func (s *Service) SetQuota(ctx context.Context, cf string, course string, quota *models.Quota) error {
+	if quota.MaxReleases < 0 || quota.MaxActiveReleases < 0 || quota.MaxReplicas < 0 {
+		return fmt.Errorf("%w: quota limits must not be negative", ErrInvalidRequest)
+	}
+	for _, value := range []string{quota.MaxCPU, quota.MaxMemory} {
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Sign() <= 0 {
+			return fmt.Errorf("%w: invalid quantity %q", ErrInvalidRequest, value)
+		}
+	}
+	var err error
+	switch {
+	case cf != "" && course == "":
+		err = s.store.SetUserQuota(ctx, cf, quota)
+	case course != "" && cf == "":
+		err = s.store.SetCourseQuota(ctx, course, quota)
+	default:
+		return fmt.Errorf("%w: exactly one of cf and course is required", ErrInvalidRequest)
+	}
+	if err != nil {
+		log.Println("Could not set quota", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package relHandler
+
+import (
+	"context"
+	"errors"
+	"helm3-manager/helmInterface"
+	"helm3-manager/models"
+	"helm3-manager/redisInterface"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReleaseResources(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       string
+		wantReplicas int
+		wantCPU      string
+		wantMemory   string
+	}{
+		{
+			name: "replicas",
+			values: `components:
+- name: web
+  image: nginx
+  active: true
+  replicas: 2
+  resources:
+    requests:
+      cpu: 500m
+      memory: 256Mi
+`,
+			wantReplicas: 2,
+			wantCPU:      "1",
+			wantMemory:   "512Mi",
+		},
+		{
+			// packs-files non dichiara request e riceve quelle di default, più grandi del container
+			name: "files init container",
+			values: `components:
+- name: web
+  image: nginx
+  active: true
+  resources:
+    requests:
+      cpu: 50m
+      memory: 64Mi
+  volumes:
+  - name: site
+    mountPath: /usr/share/nginx/html
+    directory: static
+`,
+			wantReplicas: 1,
+			wantCPU:      "100m",
+			wantMemory:   "128Mi",
+		},
+		{
+			name: "dependency init container",
+			values: `components:
+- name: db
+  image: postgres
+  active: true
+  ports:
+  - port: 5432
+- name: web
+  image: nginx
+  active: true
+  dependsOn: [db]
+  resources:
+    limits:
+      cpu: 20m
+      memory: 32Mi
+`,
+			wantReplicas: 2,
+			wantCPU:      "200m",
+			wantMemory:   "256Mi",
+		},
+		{
+			name: "job",
+			values: `components:
+- name: web
+  image: nginx
+  active: true
+  resources:
+    requests:
+      cpu: 300m
+      memory: 64Mi
+  jobs:
+    image: busybox
+    commands:
+    - command: echo done
+`,
+			wantReplicas: 2,
+			wantCPU:      "400m",
+			wantMemory:   "192Mi",
+		},
+		{
+			name: "inactive component",
+			values: `components:
+- name: web
+  image: nginx
+  active: false
+`,
+			wantReplicas: 0,
+			wantCPU:      "0",
+			wantMemory:   "0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, helmInterface.NewMemoryDeployer())
+			rel := f.addRelease(t, "web-abcde", test.values)
+			values, err := f.service.getValuesMapFromToken(rel.Jwt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := f.service.releaseResources(context.Background(), rel, values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.replicas != test.wantReplicas || got.cpu.String() != test.wantCPU || got.memory.String() != test.wantMemory {
+				t.Errorf("releaseResources() = %d replicas, %s cpu, %s memory, want %d, %s, %s",
+					got.replicas, got.cpu.String(), got.memory.String(), test.wantReplicas, test.wantCPU, test.wantMemory)
+			}
+		})
+	}
+}
+
+func TestInstallReleaseConcurrentQuota(t *testing.T) {
+	f := newFixture(t, helmInterface.NewMemoryDeployer())
+	f.service.conf.MaxActiveReleasesPerUser = 1
+	f.service.conf.MaxReleasePerUser = 3
+	rels := []string{"web-aaaaa", "web-bbbbb", "web-ccccc"}
+	errs := make([]error, len(rels))
+	var wg sync.WaitGroup
+	for i, jwt := range rels {
+		rel := f.addRelease(t, jwt, testValuesReplicas)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.service.InstallRelease(context.Background(), rel)
+		}()
+	}
+	wg.Wait()
+	installed := 0
+	for _, err := range errs {
+		if err == nil {
+			installed++
+		} else if !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("InstallRelease() error = %v, want %v", err, ErrQuotaExceeded)
+		}
+	}
+	if installed != 1 {
+		t.Errorf("%d releases installed, want 1", installed)
+	}
+}
+
+// slowCountStore ritarda il risultato del conteggio delle release, così i caricamenti
+// contemporanei contano tutti prima che uno di loro salvi la release
+type slowCountStore struct {
+	*redisInterface.MemoryStore
+}
+
+func (s slowCountStore) CountReleasesByOwner(ctx context.Context, owner string) (int64, error) {
+	count, err := s.MemoryStore.CountReleasesByOwner(ctx, owner)
+	time.Sleep(10 * time.Millisecond)
+	return count, err
+}
+
+func TestSaveToRedisConcurrentQuota(t *testing.T) {
+	f := newFixture(t, helmInterface.NewMemoryDeployer())
+	f.service.conf.MaxReleasePerUser = 1
+	f.service.store = slowCountStore{f.store}
+	jwts := []string{"web-aaaaa", "web-bbbbb", "web-ccccc"}
+	errs := make([]error, len(jwts))
+	var wg sync.WaitGroup
+	for i, jwt := range jwts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.service.SaveToRedis(context.Background(), jwt, "web", &models.User{Cf: testOwner})
+		}()
+	}
+	wg.Wait()
+	saved := 0
+	for _, err := range errs {
+		if err == nil {
+			saved++
+		} else if !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("SaveToRedis() error = %v, want %v", err, ErrQuotaExceeded)
+		}
+	}
+	count, err := f.store.CountReleasesByOwner(context.Background(), testOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != 1 || count != 1 {
+		t.Errorf("%d releases saved, %d stored, want 1", saved, count)
+	}
+}
@@ -1,33 +1,69 @@
 package relHandler
 
 import (
-	"archive/zip"
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"helm3-manager/archiveHandler"
+	"helm3-manager/config"
 	"helm3-manager/helmInterface"
 	"helm3-manager/k8sInterface"
+	"helm3-manager/models"
 	"helm3-manager/redisInterface"
+	"helm3-manager/tokenHandler"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
-	"unicode"
-
-	"github.com/golang-jwt/jwt/v4"
-	"helm.sh/helm/v3/pkg/action"
-	"k8s.io/client-go/kubernetes"
 )
 
-const maxReleasePerUser = 2
-const secretForJwt = "segretone_da_cambiare"
+// Service gestisce le release degli utenti: i file caricati sul disco, i dati salvati su Redis,
+// le release Helm e i namespace del cluster
+type Service struct {
+	conf     *config.Config
+	store    redisInterface.Store
+	deployer helmInterface.Deployer
+	cluster  k8sInterface.Cluster
+	// nil se non sono configurate le chiavi dei token firmati
+	tokens *tokenHandler.Manager
+	// un *sync.Mutex per proprietario, vedi lockOwner
+	ownerLocks sync.Map
+}
+
+func NewService(conf *config.Config, store redisInterface.Store, deployer helmInterface.Deployer, cluster k8sInterface.Cluster, tokens *tokenHandler.Manager) *Service {
+	return &Service{
+		conf:     conf,
+		store:    store,
+		deployer: deployer,
+		cluster:  cluster,
+		tokens:   tokens,
+	}
+}
+
+// lockOwner serializza le operazioni che controllano la quota e poi installano o salvano le
+// release di un proprietario, altrimenti due operazioni contemporanee potrebbero superare
+// insieme la quota;
+// helm-manager viene eseguito con una sola replica, quindi basta un mutex in memoria
+func (s *Service) lockOwner(owner string) func() {
+	lock, _ := s.ownerLocks.LoadOrStore(owner, &sync.Mutex{})
+	mutex := lock.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
 
-func RemoveFolderDirectoryIfExist(jwt string) error {
-	err := os.RemoveAll("/shared/uploads/" + jwt)
+func (s *Service) releaseDir(jwt string) string {
+	return filepath.Join(s.conf.UploadDir, jwt)
+}
+
+func (s *Service) RemoveFolderDirectoryIfExist(jwt string) error {
+	err := os.RemoveAll(s.releaseDir(jwt))
 	if err != nil {
 		log.Println("Could not remove jwt directory", err)
 		return err
@@ -35,8 +71,8 @@ func RemoveFolderDirectoryIfExist(jwt string) error {
 	return nil
 }
 
-func checkZipFilePresence(r *http.Request) (multipart.File, *multipart.FileHeader, bool) {
-	r.ParseMultipartForm(10 << 20) // max 10 MB
+func (s *Service) checkZipFilePresence(r *http.Request) (multipart.File, *multipart.FileHeader, bool) {
+	r.ParseMultipartForm(s.conf.MaxArchiveSize)
 	file, header, err := r.FormFile("zipFile")
 	if err != nil {
 		log.Println("File not found")
@@ -45,260 +81,282 @@ func checkZipFilePresence(r *http.Request) (multipart.File, *multipart.FileHeade
 	return file, header, true
 }
 
-func MakeUploadDirIfNotExist() error {
-	_, err := os.Stat("/shared/uploads")
+func (s *Service) MakeUploadDirIfNotExist() error {
+	_, err := os.Stat(s.conf.UploadDir)
 	if os.IsNotExist(err) {
-		os.Mkdir("/shared/uploads", 0755)
+		os.Mkdir(s.conf.UploadDir, 0755)
 	}
 	return nil
 }
-func adaptToK8s(token string) string { // must match regex ^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$ and be less than 53 characters
-	var builder strings.Builder
-	for _, char := range token {
-		if (unicode.IsLetter(char) && unicode.IsLower(char)) || unicode.IsNumber(char) {
-			builder.WriteRune(char)
-		} else if unicode.IsLetter(char) && unicode.IsUpper(char) {
-			builder.WriteRune(unicode.ToLower(char))
-		}
-	}
-	sanitized := builder.String()
-	if len(sanitized) > 50 {
-		return sanitized[len(sanitized)-50:]
+func (s *Service) MakeReleaseDirIfNotExist(jwt string) {
+	_, err := os.Stat(s.releaseDir(jwt))
+	if os.IsNotExist(err) {
+		os.Mkdir(s.releaseDir(jwt), 0755)
 	}
-	return sanitized
 }
 
-// unicJwt must match regex ^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$
-func MakeUnicJwt() string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"iat": time.Now().Unix(),
-	})
-	tokenString, _ := token.SignedString([]byte(time.Now().String() + secretForJwt))
-	return adaptToK8s(tokenString)
-}
-func MakeUnicJwtForNamespace(name string) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"iat": time.Now().Unix(),
-	})
-	tokenString, _ := token.SignedString([]byte(time.Now().String() + name))
-	return adaptToK8s(tokenString)
-}
-func MakeReleaseDirIfNotExist(jwt string) {
-	_, err := os.Stat("/shared/uploads/" + jwt)
-	if os.IsNotExist(err) {
-		os.Mkdir("/shared/uploads/"+jwt, 0755)
+// margine per le intestazioni multipart e i campi di testo del form di /upload e /upgrade
+const uploadFormSlack = 1 << 20
+
+// ParseUploadForm limita il corpo della richiesta alla somma delle dimensioni massime di archivio
+// e values.yaml e legge il form multipart. Va chiamata prima di leggere qualsiasi campo, anche
+// name, altrimenti FormValue leggerebbe l'intero corpo senza limiti
+func (s *Service) ParseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, s.conf.MaxArchiveSize+s.conf.MaxValuesSize+uploadFormSlack)
+	err := r.ParseMultipartForm(s.conf.MaxValuesSize)
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		log.Println("Upload too large", err)
+		return fmt.Errorf("%w: upload exceeds the maximum size of %d bytes", ErrInvalidRequest, maxBytesError.Limit)
+	}
+	if err != nil {
+		log.Println("Could not parse upload form", err)
+		return fmt.Errorf("%w: %s", ErrInvalidRequest, err.Error())
 	}
+	return nil
 }
 
-func ZipHandler(r *http.Request, jwt string) error {
-	file, handler, presence := checkZipFilePresence(r)
+// ArchiveHandler salva ed estrae l'archivio caricato nel campo zipFile; il formato (zip, tar,
+// tar.gz, tar.zst) viene riconosciuto dal contenuto del file
+func (s *Service) ArchiveHandler(r *http.Request, jwt string) error {
+	file, handler, presence := s.checkZipFilePresence(r)
 	if !presence {
 		return nil
 	}
 	defer file.Close()
-	if filepath.Ext(handler.Filename) == ".zip" {
-		// Legge il contenuto del file caricato in memoria
-		fileBytes, err := io.ReadAll(file)
-		if err != nil {
-			log.Println("Could not read file content", err)
-			return err
-		}
-		// Creazione di un reader per il file zip
-		zipReader, err := zip.NewReader(bytes.NewReader(fileBytes), int64(len(fileBytes)))
-		if err != nil {
-			log.Println("Could not open zip file", err)
-			return err
-		}
-
-		for _, file := range zipReader.File {
-			fileReader, err := file.Open()
-			if err != nil {
-				log.Println("Could not open file in zip")
-				return err
-			}
-			defer fileReader.Close()
-			//caso in cui il file è una directory
-			if file.FileInfo().IsDir() {
-				os.MkdirAll("/shared/uploads/"+jwt+"/mnt/"+file.Name, file.Mode())
-			} else {
-				//caso in cui il file è un file
-				fileToCreate, err := os.OpenFile("/shared/uploads/"+jwt+"/mnt/"+file.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-				if err != nil {
-					log.Println("Could not create file", err)
-					return err
-				}
-				defer fileToCreate.Close()
-
-				_, err = io.Copy(fileToCreate, fileReader)
-				if err != nil {
-					log.Println("Could not copy file", err)
-					return err
-				}
-			}
-		}
-	} else {
-		return fmt.Errorf("file is not a zip")
+	if handler.Size > s.conf.MaxArchiveSize {
+		return fmt.Errorf("%w: archive exceeds the maximum size of %d bytes", ErrInvalidRequest, s.conf.MaxArchiveSize)
 	}
-	return nil
-}
-func YamlHandler(r *http.Request, jwt string) error {
-	r.ParseMultipartForm(2 << 20)
-	file, handler, err := r.FormFile("yamlFile")
+	// l'archivio viene scritto su disco senza leggerlo in memoria e sostituisce quello
+	// salvato solo se l'estrazione va a buon fine
+	uploadPath := s.archivePath(jwt) + ".upload"
+	archiveToCreate, err := os.OpenFile(uploadPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
-		log.Println("File not found")
+		log.Println("Could not create archive file", err)
 		return err
 	}
-	defer file.Close()
+	_, err = io.Copy(archiveToCreate, file)
+	archiveToCreate.Close()
+	if err != nil {
+		log.Println("Could not copy archive file", err)
+		os.Remove(uploadPath)
+		return err
+	}
+	err = s.extractArchive(uploadPath, jwt)
+	if err != nil {
+		os.Remove(uploadPath)
+		return err
+	}
+	// l'archivio originale serve per ripristinare i file in caso di rollback
+	return os.Rename(uploadPath, s.archivePath(jwt))
+}
 
-	// Creazione di un file yaml
-	if filepath.Ext(handler.Filename) == ".yaml" {
-		fileToCreate, err := os.OpenFile("/shared/uploads/"+jwt+"/"+handler.Filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-		if err != nil {
-			log.Println("Could not create file", err)
-			return err
-		}
-		defer fileToCreate.Close()
+func (s *Service) valuesPath(jwt string) string {
+	return filepath.Join(s.releaseDir(jwt), "values.yaml")
+}
 
-		_, err = io.Copy(fileToCreate, file)
-		if err != nil {
-			log.Println("Could not copy file", err)
-			return err
+func (s *Service) archivePath(jwt string) string {
+	return filepath.Join(s.releaseDir(jwt), "mount.archive")
+}
+
+// estrae l'archivio in una cartella temporanea e la sostituisce a mnt solo ad estrazione completata,
+// così un archivio non valido non lascia la release con metà dei file
+func (s *Service) extractArchive(archiveFile string, jwt string) error {
+	tmpDir, err := os.MkdirTemp(s.releaseDir(jwt), "mnt-")
+	if err != nil {
+		log.Println("Could not create extraction directory", err)
+		return err
+	}
+	err = archiveHandler.Extract(archiveFile, tmpDir, archiveHandler.Limits{
+		MaxTotalSize:        s.conf.MaxExtractedSize,
+		MaxEntries:          s.conf.MaxArchiveEntries,
+		MaxCompressionRatio: s.conf.MaxCompressionRatio,
+	})
+	if err != nil {
+		log.Println("Could not extract archive", err)
+		os.RemoveAll(tmpDir)
+		if isArchiveError(err) {
+			return fmt.Errorf("%w: %s", ErrInvalidRequest, err.Error())
 		}
-	} else {
-		log.Println("File is not a yaml")
 		return err
 	}
-	return nil
+	// MkdirTemp crea la cartella con permessi 0700, i container devono poterla leggere
+	err = os.Chmod(tmpDir, 0755)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	mntDir := filepath.Join(s.releaseDir(jwt), "mnt")
+	err = os.RemoveAll(mntDir)
+	if err != nil {
+		log.Println("Could not remove mnt directory", err)
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return os.Rename(tmpDir, mntDir)
 }
 
-func SaveToRedis(jwt string, name string, token string) error {
-	cf, err := redisInterface.GetKeyValue(token)
+// distingue gli archivi non validi caricati dall'utente dagli errori di scrittura su disco
+func isArchiveError(err error) bool {
+	for _, archiveErr := range []error{
+		archiveHandler.ErrUnsafePath,
+		archiveHandler.ErrUnsupportedEntry,
+		archiveHandler.ErrTooManyEntries,
+		archiveHandler.ErrTooLarge,
+		archiveHandler.ErrCompressionRatio,
+		archiveHandler.ErrDuplicateEntry,
+		archiveHandler.ErrUnknownFormat,
+	} {
+		if errors.Is(err, archiveErr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) YamlHandler(r *http.Request, jwt string) error {
+	data, err := s.readValuesFile(r)
 	if err != nil {
-		log.Println("Could not get key", err)
 		return err
 	}
-	namespaceJwt := MakeUnicJwtForNamespace(name)
-	err = redisInterface.InsertInSet("rel-"+cf, PrepareJsonString(jwt, name, namespaceJwt))
+	err = os.WriteFile(s.valuesPath(jwt), data, 0666)
 	if err != nil {
-		log.Println("Could not insert in set", err)
+		log.Println("Could not create file", err)
 		return err
 	}
 	return nil
 }
 
-func DeleteRelease(token string, jwt string) error {
-	// controlla se la release appartiene all'utente che richiede l'installazione
-	rel, err := getReleaseFromToken(token, jwt)
+// readValuesFile legge il values.yaml caricato nel campo yamlFile; il file viene accettato
+// solo se rispetta values.schema.json
+func (s *Service) readValuesFile(r *http.Request) ([]byte, error) {
+	r.ParseMultipartForm(s.conf.MaxValuesSize)
+	file, handler, err := r.FormFile("yamlFile")
 	if err != nil {
-		log.Println("Could not get release", err)
-		return err
+		log.Println("File not found")
+		return nil, fmt.Errorf("%w: values file is missing", ErrInvalidRequest)
 	}
-	if rel == nil {
-		log.Println("Release not found")
-		return nil
+	defer file.Close()
+	if filepath.Ext(handler.Filename) != ".yaml" {
+		log.Println("File is not a yaml")
+		return nil, fmt.Errorf("%w: file is not a yaml", ErrInvalidRequest)
 	}
-	ns := rel["namespace"].(string)
-	kube_config := k8sInterface.GetKubeConfig()
-	kube_client_set, err := k8sInterface.GetKubernetesClientSet(kube_config)
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Println("Could not read file content", err)
+		return nil, err
+	}
+	fieldErrors, err := helmInterface.ValidateValues(data)
+	if err != nil {
+		log.Println("Could not validate values file", err)
+		return nil, err
+	}
+	if len(fieldErrors) > 0 {
+		log.Println("Values file does not match the schema")
+		return nil, &models.ValidationError{Errors: fieldErrors}
+	}
+	return data, nil
+}
+
+// SaveToRedis salva la nuova release dell'utente. Il numero di release viene controllato di
+// nuovo con il lock del proprietario, perché due caricamenti contemporanei superano entrambi il
+// controllo fatto all'inizio del caricamento
+func (s *Service) SaveToRedis(ctx context.Context, jwt string, name string, user *models.User) error {
+	defer s.lockOwner(user.Cf)()
+	err := s.CheckReleaseQuota(ctx, user)
 	if err != nil {
-		log.Println("Could not get Kubernetes client", err)
 		return err
 	}
-	helm_client, err := helmInterface.GetNewHelmClient(rel["namespace"].(string), kube_client_set, kube_config)
+	err = s.store.SaveRelease(ctx, &models.Release{
+		Jwt:       jwt,
+		Name:      name,
+		Namespace: releaseNamespace(jwt),
+		Owner:     user.Cf,
+		CreatedAt: time.Now(),
+	})
 	if err != nil {
-		log.Println("Could not get Helm client", err)
+		log.Println("Could not save release", err)
 		return err
 	}
+	// la release salvata occupa già l'id, se la riserva non viene liberata scade da sola
+	s.FreeReleaseID(ctx, jwt)
+	return nil
+}
+
+func (s *Service) DeleteRelease(ctx context.Context, rel *models.Release) error {
+	ns := rel.Namespace
 	// controlla se la release è già attiva, TODO: possibile dividere in due funzioni
-	check, err := helmInterface.IsReleaseActive(jwt, rel["namespace"].(string), helm_client)
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
 	if err != nil {
 		log.Println("Could not check if release is active", err)
 		return err
 	}
 	if check {
 		log.Println("Release active, cannot delete")
-	} else {
-		cf, err := redisInterface.GetKeyValue(token)
-		if err != nil {
-			log.Println("Could not get key value", err)
-			return err
-		}
-		rel, err := getReleaseStringFromToken(token, jwt)
-		if err != nil {
-			log.Println("Could not get release", err)
-			return err
-		}
-		err = redisInterface.DeleteFromSet(cf, rel)
-		if err != nil {
-			log.Println("Could not delete from set", err)
-			return err
-		}
-		err = os.RemoveAll("/shared/uploads/" + jwt)
-		if err != nil {
-			log.Println("Could not remove jwt directory", err)
-			return err
-		}
-		err = k8sInterface.RemoveNamespaceIfExists(ns)
-		if err != nil {
-			log.Println("Could not remove namespace", err)
-			return err
-		}
-
+		return ErrReleaseActive
 	}
-	return nil
-}
-func CheckNumberOfReleasePerToken(token string) (bool, error) {
-	cf, err := redisInterface.GetKeyValue(token)
+	err = s.store.DeleteRelease(ctx, rel)
 	if err != nil {
-		log.Println("Could not get key value", err)
-		return false, err
+		log.Println("Could not delete release from Redis", err)
+		return err
 	}
-	n, err := redisInterface.GetNumberOfSetFromKey("rel-" + cf)
+	err = s.store.DeleteFilesToken(ctx, rel.Jwt)
 	if err != nil {
-		log.Println("Could not get number of release", err)
-		return false, err
+		log.Println("Could not delete files token", err)
+		return err
 	}
-	return int(n) <= (maxReleasePerUser - 1), nil
-}
-
-func PrepareJsonString(jwt string, name string, nsJwt string) string {
-	return fmt.Sprintf(`{"jwt": "%s", "name": "%s", "namespace": "%s"}`, jwt, name, nsJwt)
+	err = os.RemoveAll(s.releaseDir(rel.Jwt))
+	if err != nil {
+		log.Println("Could not remove jwt directory", err)
+		return err
+	}
+	err = s.cluster.RemoveNamespaceIfExists(ctx, ns)
+	if err != nil {
+		log.Println("Could not remove namespace", err)
+		return err
+	}
+	s.deployer.ForgetNamespace(ns)
+	return nil
 }
 
-func GetReleasesList(w http.ResponseWriter, token string) (string, error) {
-	cf, err := redisInterface.GetKeyValue(token)
-	if err != nil {
-		log.Println("Could not get cf", err)
-		http.Error(w, "Error retrieving cf", http.StatusInternalServerError)
-		return "", err
+// GetReleasesList restituisce le release di owner, o dell'utente stesso se owner è vuoto;
+// docenti e amministratori possono leggere le release degli utenti a cui hanno accesso. Senza
+// owner un amministratore riceve le release consegnate di tutti gli utenti, come nella dashboard
+// di amministrazione della UI
+func (s *Service) GetReleasesList(ctx context.Context, user *models.User, owner string) (string, error) {
+	var rels []*models.Release
+	var err error
+	if owner == "" && user.Role == models.RoleAdmin {
+		rels, err = s.store.GetDeliveredReleases(ctx)
+	} else {
+		if owner == "" {
+			owner = user.Cf
+		}
+		err = s.authorizeOwner(ctx, user, owner, ActionView)
+		if err != nil {
+			return "", err
+		}
+		rels, err = s.store.GetReleasesByOwner(ctx, owner)
 	}
-	val, err := redisInterface.GetAllSetFromKey("rel-" + cf)
 	if err != nil {
-		log.Println("Could not get set from Redis", err)
-		http.Error(w, "Error retrieving set from Redis", http.StatusInternalServerError)
+		log.Println("Could not get releases from Redis", err)
 		return "", err
 	}
-	rels, err := checkAndSetActive(val)
+	err = s.checkAndSetActive(ctx, rels)
 	if err != nil {
 		log.Println("Could not check if release is active", err)
-		http.Error(w, "Error checking if release is active", http.StatusInternalServerError)
 		return "", err
 	}
-	jsonRels := combineJsonsInArray(rels...)
-
-	return jsonRels, nil
-}
-
-func combineJsonsInArray(jsons ...string) string {
-	res := "["
-	for i, j := range jsons {
-		res += j
-		if i != len(jsons)-1 {
-			res += ","
-		}
+	// il set su Redis non è ordinato, le release vengono mostrate dalla più vecchia
+	sort.Slice(rels, func(i, j int) bool {
+		return rels[i].CreatedAt.Before(rels[j].CreatedAt)
+	})
+	json_bytes, err := json.Marshal(rels)
+	if err != nil {
+		log.Println("Could not marshal json", err)
+		return "", err
 	}
-	res += "]"
-	return res
+	return string(json_bytes), nil
 }
 
 /*func getReleasesFromCF(cf string) ([]string, error) {
@@ -309,238 +367,314 @@ func combineJsonsInArray(jsons ...string) string {
 	return val, nil
 }*/
 
-func checkAndSetActive(rels []string) ([]string, error) {
-	json_rel := make(map[string]interface{})
-	checked_rels := make([]string, 0)
+func (s *Service) checkAndSetActive(ctx context.Context, rels []*models.Release) error {
 	for _, rel := range rels {
-		json.Unmarshal([]byte(rel), &json_rel)
-		helm_client, err := getHelmClientForNamespace(json_rel["namespace"].(string))
-		if err != nil {
-			log.Println("Could not get Helm client", err)
-			return nil, err
-		}
-		check, err := helmInterface.IsReleaseActive(json_rel["jwt"].(string), json_rel["namespace"].(string), helm_client)
+		err := s.setStatus(rel)
 		if err != nil {
-			log.Println("Could not check if release is active", err)
-			return nil, err
-		}
-
-		if check {
-			json_rel["status"] = "active"
-		} else {
-			json_rel["status"] = "inactive"
-		}
-		json_bytes, err := json.Marshal(json_rel)
-		if err != nil {
-			log.Println("Could not marshal json", err)
-			return nil, err
+			return err
 		}
-		rel = string(json_bytes)
-		checked_rels = append(checked_rels, rel)
 	}
-	return checked_rels, nil
+	return nil
 }
 
-func getReleaseFromToken(token string, jwt string) (map[string]interface{}, error) {
-	cf, err := redisInterface.GetKeyValue(token)
-	if err != nil {
-		log.Println("Could not get key value", err)
-		return nil, err
-	}
-	val, err := redisInterface.GetAllSetFromKey("rel-" + cf)
+// imposta lo stato della release leggendolo da Helm
+func (s *Service) setStatus(rel *models.Release) error {
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
 	if err != nil {
-		log.Println("Could not get set from Redis", err)
-		return nil, err
+		log.Println("Could not check if release is active", err)
+		return err
 	}
-	for _, rel := range val {
-		json_rel := make(map[string]interface{})
-		json.Unmarshal([]byte(rel), &json_rel)
-		if json_rel["jwt"] == jwt {
-			return json_rel, nil
-		}
-		log.Println("Release " + jwt + " not found")
+	if check {
+		rel.Status = models.ReleaseActive
+	} else {
+		rel.Status = models.ReleaseInactive
 	}
-	return nil, nil
+	return nil
 }
 
-// TODO: controllare gli http error molto probabilmente non corretti
-func InstallRelease(w http.ResponseWriter, token string, referredChart string) error {
-	// controlla se la release appartiene all'utente che richiede l'installazione
-	rel, err := getReleaseFromToken(token, referredChart)
+func (s *Service) InstallRelease(ctx context.Context, rel *models.Release) error {
+	defer s.lockOwner(rel.Owner)()
+	// controlla se la release è già attiva
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
 	if err != nil {
-		log.Println("Could not get release", err)
-		http.Error(w, "Error getting release", http.StatusInternalServerError)
+		log.Println("Could not check if release is active", err)
 		return err
 	}
-	if rel == nil {
-		log.Println("Release not found")
-		http.Error(w, "Release not found in your releases", http.StatusNotFound)
-		return nil
+	if check {
+		log.Println("Release " + rel.Jwt + " already active")
+		return ErrReleaseActive
 	}
-	helm_client, err := getHelmClientForNamespace(rel["namespace"].(string))
+	chart, err := helmInterface.CreateChart(rel.Jwt)
 	if err != nil {
-		log.Println("Could not get Helm client", err)
-		http.Error(w, "Error getting Helm client", http.StatusInternalServerError)
+		log.Println("Could not create chart", err)
 		return err
 	}
-	// controlla se la release è già attiva
-	check, err := helmInterface.IsReleaseActive(rel["jwt"].(string), rel["namespace"].(string), helm_client)
+	values, err := s.getValuesMapFromToken(rel.Jwt)
 	if err != nil {
-		log.Println("Could not check if release is active", err)
-		http.Error(w, "Error checking if release is active", http.StatusInternalServerError)
+		log.Println("Could not get values", err)
 		return err
 	}
-	if check {
-		log.Println("Release " + referredChart + " already active")
-		http.Error(w, "Release already active", http.StatusForbidden)
-		return nil
-	}
-	chart, err := helmInterface.CreateChart(referredChart)
+	err = s.checkResourceQuota(ctx, rel, values)
 	if err != nil {
-		log.Println("Could not create chart", err)
-		http.Error(w, "Error creating chart", http.StatusInternalServerError)
+		log.Println("Release", rel.Jwt, "exceeds the quota", err)
 		return err
 	}
-	values, err := getValuesMapFromToken(referredChart)
+	// un namespace già presente contiene i volumi persistenti di una installazione precedente
+	// e non va rimosso se l'installazione fallisce
+	existed, err := s.cluster.NamespaceExists(ctx, rel.Namespace)
 	if err != nil {
-		log.Println("Could not get values", err)
-		http.Error(w, "Error getting values", http.StatusInternalServerError)
+		log.Println("Could not check namespace", err)
 		return err
 	}
-	err = k8sInterface.CreateNamespaceIfNotExists(rel["namespace"].(string))
+	err = s.cluster.CreateNamespaceIfNotExists(ctx, rel.Namespace)
 	if err != nil {
 		log.Println("Error creating namespace: ", err.Error())
 		return err
 	}
-	err = helmInterface.Install(chart, values, rel["jwt"].(string), rel["namespace"].(string), helm_client)
+	err = s.prepareFiles(ctx, rel, values, true)
+	if err != nil {
+		log.Println("Could not prepare files", err)
+		s.cleanupFailedInstall(ctx, rel, !existed)
+		return err
+	}
+	err = s.deployer.Install(ctx, chart, values, rel.Jwt, rel.Namespace)
 	if err != nil {
 		log.Println("Could not install release", err)
-		http.Error(w, "Error installing release", http.StatusInternalServerError)
+		s.cleanupFailedInstall(ctx, rel, !existed)
 		return err
 	}
+	// una nuova installazione riparte dalla prima revisione
+	s.clearRevisionFiles(rel.Jwt)
+	s.snapshotCurrentRevision(ctx, rel.Jwt, rel.Namespace)
 	return nil
 }
 
-func getValuesMapFromToken(rel_jwt string) (map[string]interface{}, error) {
-	//leggi values.yaml da file usando le chartutils ufficiali
-	values, err := helmInterface.GetValues(rel_jwt)
+// cleanupFailedInstall riporta la release allo stato precedente l'installazione: Helm registra
+// le installazioni fallite, che risulterebbero attive, e il token dei file non deve restare
+// valido; il namespace viene rimosso solo se è stato creato da questa installazione
+func (s *Service) cleanupFailedInstall(ctx context.Context, rel *models.Release, removeNamespace bool) {
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
 	if err != nil {
-		log.Println("Could not get values", err)
-		return nil, err
+		log.Println("Could not check if release is active", err)
+	} else if check {
+		err = s.deployer.Uninstall(rel.Jwt, rel.Namespace)
+		if err != nil {
+			log.Println("Could not uninstall failed release", err)
+		}
 	}
-	values["rootDirectory"] = fmt.Sprintf("/shared/uploads/%s/mnt/", rel_jwt)
-	return values, nil
-}
-
-func getReleaseStringFromToken(token string, jwt string) (string, error) {
-	cf, err := redisInterface.GetKeyValue(token)
+	err = s.store.DeleteFilesToken(ctx, rel.Jwt)
 	if err != nil {
-		log.Println("Could not get key value", err)
-		return "", err
+		log.Println("Could not delete files token", err)
 	}
-	val, err := redisInterface.GetAllSetFromKey("rel-" + cf)
-	if err != nil {
-		log.Println("Could not get set from Redis", err)
-		return "", err
+	if !removeNamespace {
+		return
 	}
-	for _, rel := range val {
-		json_rel := make(map[string]string)
-		json.Unmarshal([]byte(rel), &json_rel)
-		temp := json_rel["jwt"]
-		if temp == jwt {
-			return rel, nil
-		}
+	err = s.cluster.RemoveNamespaceIfExists(ctx, rel.Namespace)
+	if err != nil {
+		log.Println("Could not remove namespace", err)
+		return
 	}
-	log.Println("Release " + jwt + " not found")
-	return "", fmt.Errorf("release not found")
+	s.deployer.ForgetNamespace(rel.Namespace)
 }
 
-func StopRelease(token string, referredChart string) error {
-	// controlla se la release appartiene all'utente che richiede l'installazione
-	rel, err := getReleaseFromToken(token, referredChart)
+// UpgradeRelease salva il nuovo values.yaml (ed eventualmente il nuovo archivio) della release
+// e, se la release è attiva, la aggiorna sul posto senza doverla fermare e reinstallare
+func (s *Service) UpgradeRelease(ctx context.Context, r *http.Request, rel *models.Release) error {
+	defer s.lockOwner(rel.Owner)()
+	// il values.yaml viene scritto solo dopo aver accettato anche l'archivio, altrimenti
+	// un archivio rifiutato lascerebbe i nuovi values con i file della revisione installata
+	data, err := s.readValuesFile(r)
 	if err != nil {
-		log.Println("Could not get release", err)
+		log.Println("Could not read values file", err)
 		return err
 	}
-	if rel == nil {
-		log.Println("Release not found")
-		return nil
+	// se presente, il nuovo archivio sostituisce completamente i file montati in precedenza
+	err = s.ArchiveHandler(r, rel.Jwt)
+	if err != nil {
+		log.Println("Could not extract archive", err)
+		return err
 	}
-	helm_client, err := getHelmClientForNamespace(rel["namespace"].(string))
+	err = os.WriteFile(s.valuesPath(rel.Jwt), data, 0666)
 	if err != nil {
-		log.Println("Could not get Helm client", err)
+		log.Println("Could not create file", err)
 		return err
 	}
-	// controlla se la release è già attiva
-	check, err := helmInterface.IsReleaseActive(rel["jwt"].(string), rel["namespace"].(string), helm_client)
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
 	if err != nil {
 		log.Println("Could not check if release is active", err)
 		return err
 	}
 	if !check {
-		log.Println("Release not active")
+		// i nuovi file verranno usati alla prossima installazione
+		log.Println("Release " + rel.Jwt + " not active, files updated only")
 		return nil
 	}
-	err = helmInterface.UninstallRelease(rel["jwt"].(string), rel["namespace"].(string), helm_client)
+	revision, err := s.deployer.GetCurrentRevision(rel.Jwt, rel.Namespace)
 	if err != nil {
-		log.Println("Could not uninstall release", err)
+		log.Println("Could not get current revision", err)
+		return err
+	}
+	chart, err := helmInterface.CreateChart(rel.Jwt)
+	if err != nil {
+		log.Println("Could not create chart", err)
 		return err
 	}
+	values, err := s.getValuesMapFromToken(rel.Jwt)
+	if err != nil {
+		log.Println("Could not get values", err)
+		s.restoreRevisionFiles(rel.Jwt, revision)
+		return err
+	}
+	err = s.checkResourceQuota(ctx, rel, values)
+	if err != nil {
+		log.Println("Release", rel.Jwt, "exceeds the quota", err)
+		s.restoreRevisionFiles(rel.Jwt, revision)
+		return err
+	}
+	err = s.prepareFiles(ctx, rel, values, true)
+	if err != nil {
+		log.Println("Could not prepare files", err)
+		s.restoreRevisionFiles(rel.Jwt, revision)
+		return err
+	}
+	err = s.deployer.Upgrade(ctx, chart, values, rel.Jwt, rel.Namespace)
+	if err != nil {
+		log.Println("Could not upgrade release", err)
+		// i file tornano quelli della revisione ancora in esecuzione
+		s.restoreRevisionFiles(rel.Jwt, revision)
+		return err
+	}
+	s.snapshotCurrentRevision(ctx, rel.Jwt, rel.Namespace)
 	return nil
 }
 
-func getK8sClientSetAndConfig() (*kubernetes.Clientset, string, error) {
-	kube_config := k8sInterface.GetKubeConfig()
-	kube_client_set, err := k8sInterface.GetKubernetesClientSet(kube_config)
+// salva i file correnti come file della revisione appena creata da Helm
+func (s *Service) snapshotCurrentRevision(ctx context.Context, rel_jwt string, namespace string) error {
+	revision, err := s.deployer.GetCurrentRevision(rel_jwt, namespace)
 	if err != nil {
-		log.Println("Could not get Kubernetes client", err)
-		return nil, "", err
+		log.Println("Could not get current revision", err)
+		return err
 	}
-	return kube_client_set, kube_config, nil
+	return s.saveRevisionFiles(rel_jwt, revision)
 }
 
-func getHelmClientForNamespace(namespace string) (*action.Configuration, error) {
-	kube_client_set, kube_config, err := getK8sClientSetAndConfig()
+func (s *Service) GetReleaseHistory(ctx context.Context, rel *models.Release) (string, error) {
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
 	if err != nil {
-		log.Println("Could not get Kubernetes client", err)
-		return nil, err
+		log.Println("Could not check if release is active", err)
+		return "", err
 	}
-	helm_client, err := helmInterface.GetNewHelmClient(namespace, kube_client_set, kube_config)
+	history := make([]map[string]interface{}, 0)
+	if check {
+		rels, err := s.deployer.GetHistory(rel.Jwt, rel.Namespace)
+		if err != nil {
+			log.Println("Could not get release history", err)
+			return "", err
+		}
+		for _, r := range rels {
+			history = append(history, map[string]interface{}{
+				"revision":    r.Version,
+				"status":      r.Info.Status.String(),
+				"updated":     r.Info.LastDeployed.Time,
+				"description": r.Info.Description,
+				"files":       s.hasRevisionFiles(rel.Jwt, r.Version),
+			})
+		}
+	}
+	json_bytes, err := json.Marshal(struct {
+		*models.Release
+		History []map[string]interface{} `json:"history"`
+	}{rel, history})
 	if err != nil {
-		log.Println("Could not get Helm client", err)
-		return nil, err
+		log.Println("Could not marshal json", err)
+		return "", err
 	}
-	return helm_client, nil
+	return string(json_bytes), nil
 }
 
-func GetReleaseDetails(token string, jwt string) (string, error) {
-	json_rel, err := getReleaseFromToken(token, jwt)
+// RollbackRelease riporta la release attiva alla revisione indicata ripristinando anche
+// values.yaml e i file montati di quella revisione
+func (s *Service) RollbackRelease(ctx context.Context, rel *models.Release, revision string) error {
+	defer s.lockOwner(rel.Owner)()
+	version, err := strconv.Atoi(revision)
+	if err != nil || version < 1 {
+		log.Println("Invalid revision", revision)
+		return fmt.Errorf("%w: invalid revision %q", ErrInvalidRequest, revision)
+	}
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
 	if err != nil {
-		log.Println("Could not get release", err)
+		log.Println("Could not check if release is active", err)
+		return err
+	}
+	if !check {
+		log.Println("Release not active")
+		return ErrReleaseInactive
+	}
+	current, err := s.deployer.GetCurrentRevision(rel.Jwt, rel.Namespace)
+	if err != nil {
+		log.Println("Could not get current revision", err)
+		return err
+	}
+	err = s.restoreRevisionFiles(rel.Jwt, version)
+	if err != nil {
+		log.Println("Could not restore files of revision", version, err)
+		return err
+	}
+	// i values della revisione potrebbero chiedere più risorse di quelle concesse ora
+	values, err := s.getValuesMapFromToken(rel.Jwt)
+	if err == nil {
+		err = s.checkResourceQuota(ctx, rel, values)
+	}
+	if err == nil {
+		err = s.prepareFiles(ctx, rel, values, true)
+	}
+	if err != nil {
+		log.Println("Release", rel.Jwt, "cannot be rolled back", err)
+		s.restoreRevisionFiles(rel.Jwt, current)
+		return err
+	}
+	err = s.deployer.Rollback(rel.Jwt, rel.Namespace, version)
+	if err != nil {
+		log.Println("Could not rollback release", err)
+		s.restoreRevisionFiles(rel.Jwt, current)
+		return err
+	}
+	s.snapshotCurrentRevision(ctx, rel.Jwt, rel.Namespace)
+	return nil
+}
+
+// RenderRelease genera i manifest della release a partire dai values salvati, senza installarla
+func (s *Service) RenderRelease(ctx context.Context, rel *models.Release) (string, error) {
+	chart, err := helmInterface.CreateChart(rel.Jwt)
+	if err != nil {
+		log.Println("Could not create chart", err)
 		return "", err
 	}
-	helm_client, err := getHelmClientForNamespace(json_rel["namespace"].(string))
+	values, err := s.getValuesMapFromToken(rel.Jwt)
 	if err != nil {
-		log.Println("Could not get Helm client", err)
+		log.Println("Could not get values", err)
 		return "", err
 	}
-	check, err := helmInterface.IsReleaseActive(json_rel["jwt"].(string), json_rel["namespace"].(string), helm_client)
+	err = s.prepareFiles(ctx, rel, values, false)
 	if err != nil {
-		log.Println("Could not check if release is active", err)
+		log.Println("Could not prepare files", err)
 		return "", err
 	}
-	if check {
-		json_rel["status"] = "active"
-	} else {
-		json_rel["status"] = "inactive"
+	rendered, err := helmInterface.Render(chart, values, rel.Jwt, rel.Namespace)
+	if err != nil {
+		log.Println("Could not render release", err)
+		return "", fmt.Errorf("%w: %s", ErrInvalidValues, err.Error())
 	}
-	details, err := k8sInterface.GetDeploymentsDetails(json_rel["namespace"].(string))
+	objects, err := k8sInterface.DescribeManifests(helmInterface.SplitManifests(rendered.Manifest)...)
 	if err != nil {
-		log.Println("Could not get deployments details", err)
-		return "", err
+		log.Println("Could not describe manifests", err)
+		return "", fmt.Errorf("%w: %s", ErrInvalidValues, err.Error())
 	}
-	json_rel["details"] = details
-	json_bytes, err := json.Marshal(json_rel)
+	json_bytes, err := json.Marshal(struct {
+		*models.Release
+		Manifest string                   `json:"manifest"`
+		Objects  []map[string]interface{} `json:"objects"`
+	}{rel, rendered.Manifest, objects})
 	if err != nil {
 		log.Println("Could not marshal json", err)
 		return "", err
@@ -548,33 +682,81 @@ func GetReleaseDetails(token string, jwt string) (string, error) {
 	return string(json_bytes), nil
 }
 
-func GetReleaseLogs(token string, jwt string, podName string) (string, error) {
-	json_rel, err := getReleaseFromToken(token, jwt)
+func (s *Service) getValuesMapFromToken(rel_jwt string) (map[string]interface{}, error) {
+	//leggi values.yaml da file usando le chartutils ufficiali
+	values, err := helmInterface.GetValues(s.valuesPath(rel_jwt))
+	if err != nil {
+		log.Println("Could not get values", err)
+		return nil, err
+	}
+	return values, nil
+}
+
+func (s *Service) StopRelease(ctx context.Context, rel *models.Release) error {
+	// controlla se la release è già attiva
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
+	if err != nil {
+		log.Println("Could not check if release is active", err)
+		return err
+	}
+	if !check {
+		log.Println("Release not active")
+		return nil
+	}
+	err = s.deployer.Uninstall(rel.Jwt, rel.Namespace)
+	if err != nil {
+		log.Println("Could not uninstall release", err)
+		return err
+	}
+	// senza pod attivi nessuno deve più poter scaricare i file
+	err = s.store.DeleteFilesToken(ctx, rel.Jwt)
+	if err != nil {
+		log.Println("Could not delete files token", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Service) GetReleaseDetails(ctx context.Context, rel *models.Release) (string, error) {
+	err := s.setStatus(rel)
 	if err != nil {
-		log.Println("Could not get release", err)
 		return "", err
 	}
-	helm_client, err := getHelmClientForNamespace(json_rel["namespace"].(string))
+	details, err := s.cluster.GetDeploymentsDetails(ctx, rel.Namespace)
 	if err != nil {
-		log.Println("Could not get Helm client", err)
+		log.Println("Could not get deployments details", err)
 		return "", err
 	}
-	check, err := helmInterface.IsReleaseActive(json_rel["jwt"].(string), json_rel["namespace"].(string), helm_client)
+	json_bytes, err := json.Marshal(struct {
+		*models.Release
+		Details []map[string]interface{} `json:"details"`
+	}{rel, details})
+	if err != nil {
+		log.Println("Could not marshal json", err)
+		return "", err
+	}
+	return string(json_bytes), nil
+}
+
+func (s *Service) GetReleaseLogs(ctx context.Context, rel *models.Release, podName string) (string, error) {
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
 	if err != nil {
 		log.Println("Could not check if release is active", err)
 		return "", err
 	}
 	if !check {
 		log.Println("Release not active")
-		return "", nil
+		return "", ErrReleaseInactive
 	}
-	logs, err := k8sInterface.GetLogsFromPods(json_rel["namespace"].(string), podName)
+	logs, err := s.cluster.GetLogsFromPods(ctx, rel.Namespace, podName)
 	if err != nil {
 		log.Println("Could not get pod logs", err)
 		return "", err
 	}
-	json_rel["logs"] = logs
-	json_bytes, err := json.Marshal(json_rel)
+	json_bytes, err := json.Marshal(struct {
+		*models.Release
+		Logs string `json:"logs"`
+	}{rel, logs})
 	if err != nil {
 		log.Println("Could not marshal json", err)
 		return "", err
@@ -582,54 +764,58 @@ func GetReleaseLogs(token string, jwt string, podName string) (string, error) {
 	return string(json_bytes), nil
 }
 
-func GetReleaseFromCf(cf string, rel_token string) (string, error) {
-	val, err := redisInterface.GetAllSetFromKey("rel-" + cf)
+// StreamReleaseLogs apre lo stream dei log di un pod della release, solo se la release è attiva
+func (s *Service) StreamReleaseLogs(ctx context.Context, rel *models.Release, podName string, options k8sInterface.LogOptions) (io.ReadCloser, error) {
+	err := s.checkActive(rel)
 	if err != nil {
-		log.Println("Could not get set from Redis", err)
-		return "", err
-	}
-	for _, rel := range val {
-		json_rel := make(map[string]interface{})
-		json.Unmarshal([]byte(rel), &json_rel)
-		if json_rel["jwt"] == rel_token {
-			return rel, nil
-		}
+		return nil, err
 	}
-	return "", fmt.Errorf("release not found")
+	// il pod viene cercato nel namespace della release, quindi non si possono leggere pod di altri utenti
+	return s.cluster.StreamLogsFromPod(ctx, rel.Namespace, podName, options)
 }
 
-func DeliverRelease(token string, referredChart string) error {
-	rel, err := getReleaseStringFromToken(token, referredChart)
+// restituisce ErrReleaseInactive se la release non è installata
+func (s *Service) checkActive(rel *models.Release) error {
+	check, err := s.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
 	if err != nil {
-		log.Println("Could not get release", err)
+		log.Println("Could not check if release is active", err)
 		return err
 	}
-	if rel == "" {
-		log.Println("Release not found")
-		return nil
-	}
-	err = redisInterface.InsertInSet("rel-admin", rel)
-	if err != nil {
-		log.Println("Could not insert in set", err)
-		return err
+	if !check {
+		log.Println("Release not active")
+		return ErrReleaseInactive
 	}
 	return nil
 }
 
-func UndeliverRelease(token string, referredChart string) error {
-	log.Println("Undeliver release", token, referredChart)
-	rel, err := getReleaseStringFromToken(token, referredChart)
+// CheckReleaseActive verifica che la release sia attiva prima di aprire il terminale
+func (s *Service) CheckReleaseActive(ctx context.Context, rel *models.Release) error {
+	return s.checkActive(rel)
+}
+
+// ExecInReleasePod esegue un comando in un pod della release, solo se la release è attiva;
+// il pod viene cercato esclusivamente nel namespace della release
+func (s *Service) ExecInReleasePod(ctx context.Context, rel *models.Release, podName string, options k8sInterface.ExecOptions) error {
+	err := s.checkActive(rel)
 	if err != nil {
-		log.Println("Could not get release", err)
 		return err
 	}
-	if rel == "" {
-		log.Println("Release not found")
-		return nil
-	}
-	err = redisInterface.DeleteFromSet("admin", rel)
+	return s.cluster.ExecInPod(ctx, rel.Namespace, podName, options)
+}
+
+func (s *Service) DeliverRelease(ctx context.Context, rel *models.Release) error {
+	return s.setDelivered(ctx, rel, true)
+}
+
+func (s *Service) UndeliverRelease(ctx context.Context, rel *models.Release) error {
+	log.Println("Undeliver release", rel.Jwt)
+	return s.setDelivered(ctx, rel, false)
+}
+
+func (s *Service) setDelivered(ctx context.Context, rel *models.Release, delivered bool) error {
+	err := s.store.SetDelivered(ctx, rel.Jwt, delivered)
 	if err != nil {
-		log.Println("Could not delete from set", err)
+		log.Println("Could not update release", err)
 		return err
 	}
 	return nil
@@ -0,0 +1,483 @@
+package relHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"helm3-manager/config"
+	"helm3-manager/helmInterface"
+	"helm3-manager/k8sInterface"
+	"helm3-manager/models"
+	"helm3-manager/redisInterface"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	v1n "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestMain(m *testing.M) {
+	// CreateChart e ValidateValues leggono template.yaml e values.schema.json dalla cartella del modulo
+	err := os.Chdir("..")
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+const (
+	testOwner = "student1"
+	// la cartella static viene copiata dall'init container, quindi serve la claim dei file
+	testValues = `components:
+- name: web
+  image: nginx
+  active: true
+  ports:
+  - port: 80
+  volumes:
+  - name: site
+    mountPath: /usr/share/nginx/html
+    directory: static
+`
+	testValuesReplicas = `components:
+- name: web
+  image: nginx
+  active: true
+  replicas: 3
+`
+	testValuesMissingFile = `components:
+- name: web
+  image: nginx
+  active: true
+  volumes:
+  - name: conf
+    mountPath: /etc/nginx/nginx.conf
+    file: nginx.conf
+`
+)
+
+type fixture struct {
+	service  *Service
+	store    *redisInterface.MemoryStore
+	deployer helmInterface.Deployer
+	cluster  *k8sInterface.Client
+}
+
+func newFixture(t *testing.T, deployer helmInterface.Deployer, objects ...runtime.Object) *fixture {
+	t.Helper()
+	conf := config.Default()
+	conf.UploadDir = t.TempDir()
+	store := redisInterface.NewMemoryStore()
+	cluster := k8sInterface.NewFakeClient(conf.NamespacePolicy, objects...)
+	return &fixture{
+		service:  NewService(conf, store, deployer, cluster, nil),
+		store:    store,
+		deployer: deployer,
+		cluster:  cluster,
+	}
+}
+
+// addRelease salva su Redis e sul disco una release caricata da testOwner con i values indicati
+func (f *fixture) addRelease(t *testing.T, jwt string, values string) *models.Release {
+	t.Helper()
+	fieldErrors, err := helmInterface.ValidateValues([]byte(values))
+	if err != nil || len(fieldErrors) > 0 {
+		t.Fatalf("invalid test values: %v %v", fieldErrors, err)
+	}
+	err = os.MkdirAll(filepath.Join(f.service.releaseDir(jwt), "mnt", "static"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(f.service.releaseDir(jwt), "mnt", "static", "index.html"), []byte("hello"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(f.service.valuesPath(jwt), []byte(values), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.service.SaveToRedis(context.Background(), jwt, jwt, &models.User{Cf: testOwner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := f.store.GetRelease(context.Background(), jwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rel
+}
+
+// assertState controlla lo stato della release su Helm, nel cluster e su Redis
+func (f *fixture) assertState(t *testing.T, rel *models.Release, active bool, namespace bool, filesToken bool) {
+	t.Helper()
+	ctx := context.Background()
+	gotActive, err := f.deployer.IsReleaseActive(rel.Jwt, rel.Namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotActive != active {
+		t.Errorf("release active = %v, want %v", gotActive, active)
+	}
+	gotNamespace, err := f.cluster.NamespaceExists(ctx, rel.Namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotNamespace != namespace {
+		t.Errorf("namespace exists = %v, want %v", gotNamespace, namespace)
+	}
+	token, err := f.store.GetFilesToken(ctx, rel.Jwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (token != "") != filesToken {
+		t.Errorf("files token = %q, want present = %v", token, filesToken)
+	}
+}
+
+func namespaceObject(name string) *v1n.Namespace {
+	return &v1n.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func TestInstallRelease(t *testing.T) {
+	errCreate := errors.New("create failed")
+	tests := []struct {
+		name   string
+		values string
+		// la creazione delle risorse fallisce come su un cluster che rifiuta i manifest
+		failing bool
+		// namespace già presente, ad esempio con i volumi di una installazione precedente
+		existingNamespace bool
+		setup             func(t *testing.T, f *fixture, rel *models.Release)
+		wantErr           error
+		wantActive        bool
+		wantNamespace     bool
+		wantToken         bool
+	}{
+		{
+			name:          "installs the release",
+			values:        testValues,
+			wantActive:    true,
+			wantNamespace: true,
+			wantToken:     true,
+		},
+		{
+			name:   "rejects a release already active",
+			values: testValues,
+			setup: func(t *testing.T, f *fixture, rel *models.Release) {
+				err := f.service.InstallRelease(context.Background(), rel)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr:       ErrReleaseActive,
+			wantActive:    true,
+			wantNamespace: true,
+			wantToken:     true,
+		},
+		{
+			name:   "rejects a release over the quota before creating the namespace",
+			values: testValuesReplicas,
+			setup: func(t *testing.T, f *fixture, rel *models.Release) {
+				err := f.store.SetUserQuota(context.Background(), testOwner, &models.Quota{MaxReplicas: 2})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: ErrQuotaExceeded,
+		},
+		{
+			name:    "rejects a missing file without leaving the namespace",
+			values:  testValuesMissingFile,
+			wantErr: ErrInvalidValues,
+		},
+		{
+			name:    "rolls back a failed helm install",
+			values:  testValues,
+			failing: true,
+			wantErr: errCreate,
+		},
+		{
+			name:              "keeps an existing namespace when helm fails",
+			values:            testValues,
+			failing:           true,
+			existingNamespace: true,
+			wantErr:           errCreate,
+			wantNamespace:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var deployer helmInterface.Deployer = helmInterface.NewMemoryDeployer()
+			if test.failing {
+				deployer = helmInterface.NewFailingMemoryDeployer(errCreate)
+			}
+			objects := make([]runtime.Object, 0)
+			if test.existingNamespace {
+				objects = append(objects, namespaceObject(releaseNamespace("web-abcde")))
+			}
+			f := newFixture(t, deployer, objects...)
+			rel := f.addRelease(t, "web-abcde", test.values)
+			if test.setup != nil {
+				test.setup(t, f, rel)
+			}
+			err := f.service.InstallRelease(context.Background(), rel)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("InstallRelease() error = %v, want %v", err, test.wantErr)
+			}
+			f.assertState(t, rel, test.wantActive, test.wantNamespace, test.wantToken)
+		})
+	}
+}
+
+func TestStopRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		install bool
+	}{
+		{name: "uninstalls an active release", install: true},
+		{name: "ignores a release not active", install: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, helmInterface.NewMemoryDeployer())
+			rel := f.addRelease(t, "web-abcde", testValues)
+			if test.install {
+				err := f.service.InstallRelease(context.Background(), rel)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := f.service.StopRelease(context.Background(), rel)
+			if err != nil {
+				t.Fatalf("StopRelease() error = %v", err)
+			}
+			// il namespace resta per i volumi persistenti, il token dei file no
+			f.assertState(t, rel, false, test.install, false)
+		})
+	}
+}
+
+func TestDeleteRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		install bool
+		stop    bool
+		wantErr error
+	}{
+		{name: "deletes a release never installed"},
+		{name: "deletes a stopped release", install: true, stop: true},
+		{name: "rejects an active release", install: true, wantErr: ErrReleaseActive},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newFixture(t, helmInterface.NewMemoryDeployer())
+			rel := f.addRelease(t, "web-abcde", testValues)
+			if test.install {
+				err := f.service.InstallRelease(ctx, rel)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.stop {
+				err := f.service.StopRelease(ctx, rel)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := f.service.DeleteRelease(ctx, rel)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("DeleteRelease() error = %v, want %v", err, test.wantErr)
+			}
+			_, err = f.store.GetRelease(ctx, rel.Jwt)
+			_, statErr := os.Stat(f.service.releaseDir(rel.Jwt))
+			if test.wantErr != nil {
+				// la release attiva resta com'era
+				if err != nil || statErr != nil {
+					t.Errorf("active release was modified: %v %v", err, statErr)
+				}
+				f.assertState(t, rel, true, true, true)
+				return
+			}
+			if !errors.Is(err, models.ErrReleaseNotFound) {
+				t.Errorf("release still on Redis: %v", err)
+			}
+			if !os.IsNotExist(statErr) {
+				t.Errorf("release directory still exists: %v", statErr)
+			}
+			owned, err := f.store.CountReleasesByOwner(ctx, testOwner)
+			if err != nil || owned != 0 {
+				t.Errorf("owner index = %d %v, want 0", owned, err)
+			}
+			f.assertState(t, rel, false, false, false)
+		})
+	}
+}
+
+func TestDeliverRelease(t *testing.T) {
+	tests := []struct {
+		name          string
+		jwt           string
+		deliver       bool
+		undeliver     bool
+		wantErr       error
+		wantDelivered bool
+	}{
+		{name: "delivers a release", jwt: "web-abcde", deliver: true, wantDelivered: true},
+		{name: "undelivers a delivered release", jwt: "web-abcde", deliver: true, undeliver: true},
+		{name: "rejects a missing release", jwt: "missing-abcde", deliver: true, wantErr: ErrReleaseNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newFixture(t, helmInterface.NewMemoryDeployer())
+			f.addRelease(t, "web-abcde", testValues)
+			rel := &models.Release{Jwt: test.jwt, Owner: testOwner}
+			var err error
+			if test.deliver {
+				err = f.service.DeliverRelease(ctx, rel)
+			}
+			if err == nil && test.undeliver {
+				err = f.service.UndeliverRelease(ctx, rel)
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr != nil {
+				return
+			}
+			saved, err := f.store.GetRelease(ctx, test.jwt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Delivered != test.wantDelivered {
+				t.Errorf("delivered = %v, want %v", saved.Delivered, test.wantDelivered)
+			}
+		})
+	}
+}
+
+func TestGetReleasesListAfterMigration(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, helmInterface.NewMemoryDeployer())
+	// release salvate prima del nuovo schema: rel-<cf> per utente e rel-admin per le consegnate
+	legacy := map[string][]string{
+		"rel-student1": {"shop-aaaaa", "blog-bbbbb"},
+		"rel-student2": {"game-ccccc"},
+		"rel-admin":    {"shop-aaaaa", "game-ccccc"},
+	}
+	for key, jwts := range legacy {
+		for _, jwt := range jwts {
+			f.store.AddSetMember(key, `{"jwt":"`+jwt+`","name":"`+jwt+`","namespace":"packs-`+jwt+`"}`)
+		}
+	}
+	migrated, err := f.store.MigrateLegacyReleases(ctx)
+	if err != nil || migrated != 3 {
+		t.Fatalf("MigrateLegacyReleases() = %d, %v, want 3 releases", migrated, err)
+	}
+	// i vecchi set vengono eliminati, una nuova migrazione non trova nulla
+	migrated, err = f.store.MigrateLegacyReleases(ctx)
+	if err != nil || migrated != 0 {
+		t.Fatalf("second MigrateLegacyReleases() = %d, %v, want 0 releases", migrated, err)
+	}
+	tests := []struct {
+		name     string
+		user     *models.User
+		owner    string
+		wantJwts []string
+		wantErr  error
+	}{
+		{
+			name:     "admin sees the releases delivered before the migration",
+			user:     &models.User{Cf: "admin", Role: models.RoleAdmin},
+			wantJwts: []string{"game-ccccc", "shop-aaaaa"},
+		},
+		{
+			name:     "admin reads the releases of a user",
+			user:     &models.User{Cf: "admin", Role: models.RoleAdmin},
+			owner:    "student1",
+			wantJwts: []string{"blog-bbbbb", "shop-aaaaa"},
+		},
+		{
+			name:     "student sees the own releases",
+			user:     &models.User{Cf: "student2", Role: models.RoleStudent},
+			wantJwts: []string{"game-ccccc"},
+		},
+		{
+			name:    "student cannot read the releases of another user",
+			user:    &models.User{Cf: "student2", Role: models.RoleStudent},
+			owner:   "student1",
+			wantErr: ErrForbidden,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list, err := f.service.GetReleasesList(ctx, test.user, test.owner)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("GetReleasesList() error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr != nil {
+				return
+			}
+			var rels []models.Release
+			err = json.Unmarshal([]byte(list), &rels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwts := make([]string, 0, len(rels))
+			for _, rel := range rels {
+				jwts = append(jwts, rel.Jwt)
+			}
+			slices.Sort(jwts)
+			if !slices.Equal(jwts, test.wantJwts) {
+				t.Errorf("releases = %v, want %v", jwts, test.wantJwts)
+			}
+		})
+	}
+}
+
+func TestParseUploadForm(t *testing.T) {
+	tests := []struct {
+		name        string
+		archiveSize int
+		wantErr     error
+	}{
+		{name: "within limit", archiveSize: 1 << 10},
+		{name: "body too large", archiveSize: 4 << 20, wantErr: ErrInvalidRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, helmInterface.NewMemoryDeployer())
+			f.service.conf.MaxArchiveSize = 1 << 20
+			f.service.conf.MaxValuesSize = 1 << 10
+			body := &bytes.Buffer{}
+			form := multipart.NewWriter(body)
+			form.WriteField("name", "shop")
+			archive, err := form.CreateFormFile("zipFile", "site.zip")
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive.Write(bytes.Repeat([]byte("a"), test.archiveSize))
+			form.Close()
+			r := httptest.NewRequest("POST", "/upload", body)
+			r.Header.Set("Content-Type", form.FormDataContentType())
+
+			err = f.service.ParseUploadForm(httptest.NewRecorder(), r)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("ParseUploadForm() error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr == nil && r.FormValue("name") != "shop" {
+				t.Errorf("name = %q, want shop", r.FormValue("name"))
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package relHandler
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ogni revisione Helm della release ha una copia dei file caricati in
+// <uploadDir>/<jwt>/revisions/<revisione>, così un rollback può ripristinarli
+
+func (s *Service) revisionDir(jwt string, revision int) string {
+	return filepath.Join(s.releaseDir(jwt), "revisions", strconv.Itoa(revision))
+}
+
+func (s *Service) hasRevisionFiles(jwt string, revision int) bool {
+	_, err := os.Stat(filepath.Join(s.revisionDir(jwt, revision), "values.yaml"))
+	return err == nil
+}
+
+// salva values.yaml e l'archivio correnti come file della revisione indicata
+func (s *Service) saveRevisionFiles(jwt string, revision int) error {
+	dir := s.revisionDir(jwt, revision)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Println("Could not create revision directory", err)
+		return err
+	}
+	err = copyFile(s.valuesPath(jwt), filepath.Join(dir, "values.yaml"))
+	if err != nil {
+		log.Println("Could not save values file for revision", revision, err)
+		return err
+	}
+	os.Remove(filepath.Join(dir, "mount.archive"))
+	_, err = os.Stat(s.archivePath(jwt))
+	if err == nil {
+		err = copyFile(s.archivePath(jwt), filepath.Join(dir, "mount.archive"))
+		if err != nil {
+			log.Println("Could not save archive for revision", revision, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// ripristina values.yaml e i file montati della revisione indicata
+func (s *Service) restoreRevisionFiles(jwt string, revision int) error {
+	dir := s.revisionDir(jwt, revision)
+	if !s.hasRevisionFiles(jwt, revision) {
+		return fmt.Errorf("no files stored for revision %d", revision)
+	}
+	err := copyFile(filepath.Join(dir, "values.yaml"), s.valuesPath(jwt))
+	if err != nil {
+		log.Println("Could not restore values file of revision", revision, err)
+		return err
+	}
+	_, err = os.Stat(filepath.Join(dir, "mount.archive"))
+	if os.IsNotExist(err) {
+		// la revisione non aveva un archivio, i file montati restano invariati
+		return nil
+	}
+	err = s.extractArchive(filepath.Join(dir, "mount.archive"), jwt)
+	if err != nil {
+		log.Println("Could not extract archive of revision", revision, err)
+		return err
+	}
+	err = copyFile(filepath.Join(dir, "mount.archive"), s.archivePath(jwt))
+	if err != nil {
+		log.Println("Could not restore archive of revision", revision, err)
+		return err
+	}
+	return nil
+}
+
+// la cronologia Helm riparte da zero ad ogni installazione, quindi anche i file delle revisioni
+func (s *Service) clearRevisionFiles(jwt string) error {
+	err := os.RemoveAll(filepath.Join(s.releaseDir(jwt), "revisions"))
+	if err != nil {
+		log.Println("Could not remove revisions directory", err)
+		return err
+	}
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+	_, err = io.Copy(dstFile, srcFile)
+	return err
+}
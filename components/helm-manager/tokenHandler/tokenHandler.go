@@ -0,0 +1,205 @@
+package tokenHandler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"helm3-manager/config"
+	"helm3-manager/models"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// issuer dei token emessi, i token con un issuer diverso vengono rifiutati
+const issuer = "helm-manager"
+
+var ErrInvalidToken = errors.New("invalid session token")
+
+// Claims sono i dati contenuti nel token: ID (jti) identifica il token nella denylist di Redis,
+// OrigIssuedAt (orig_iat) il login da cui è stato emesso il token. Role è il ruolo al momento
+// dell'emissione, per i servizi che verificano il token da soli; helm-manager lo rilegge da Redis
+type Claims struct {
+	Cf           string           `json:"cf"`
+	Role         string           `json:"role"`
+	OrigIssuedAt *jwt.NumericDate `json:"orig_iat"`
+	jwt.RegisteredClaims
+}
+
+// Manager emette e verifica i token di sessione. La verifica usa solo le chiavi configurate,
+// quindi altri servizi con le stesse chiavi pubbliche possono verificarli senza interrogare Redis
+type Manager struct {
+	method     jwt.SigningMethod
+	signingKey interface{}
+	keyID      string
+	// chiavi accettate in verifica per kid; con HS256 c'è una sola chiave con kid vuoto
+	verificationKeys map[string]interface{}
+	ttl              time.Duration
+	maxAge           time.Duration
+}
+
+func NewManager(conf config.SessionConfig) (*Manager, error) {
+	manager := &Manager{
+		verificationKeys: make(map[string]interface{}),
+		ttl:              time.Duration(conf.TTLSeconds) * time.Second,
+		maxAge:           time.Duration(conf.MaxAgeSeconds) * time.Second,
+	}
+	if conf.Algorithm == "RS256" {
+		err := manager.loadRSAKeys(conf)
+		if err != nil {
+			return nil, err
+		}
+		return manager, nil
+	}
+	manager.method = jwt.SigningMethodHS256
+	manager.signingKey = []byte(conf.HMACKey)
+	manager.verificationKeys[""] = []byte(conf.HMACKey)
+	return manager, nil
+}
+
+func (m *Manager) loadRSAKeys(conf config.SessionConfig) error {
+	m.method = jwt.SigningMethodRS256
+	data, err := os.ReadFile(conf.SigningKeyFile)
+	if err != nil {
+		log.Println("Could not read signing key", err)
+		return err
+	}
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		log.Println("Could not parse signing key", err)
+		return fmt.Errorf("signing key %s: %w", conf.SigningKeyFile, err)
+	}
+	m.signingKey = privateKey
+	m.keyID = conf.SigningKeyID
+	m.verificationKeys[conf.SigningKeyID] = &privateKey.PublicKey
+	if conf.VerificationKeysDir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(conf.VerificationKeysDir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		if kid == conf.SigningKeyID {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Println("Could not read verification key", err)
+			return err
+		}
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			log.Println("Could not parse verification key", err)
+			return fmt.Errorf("verification key %s: %w", file, err)
+		}
+		m.verificationKeys[kid] = publicKey
+	}
+	return nil
+}
+
+// Issue firma un nuovo token per l'utente, valido per la durata configurata; i token vengono
+// emessi solo dalle sessioni della UI, quindi orig_iat è il momento dell'emissione
+func (m *Manager) Issue(user *models.User) (string, *Claims, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		log.Println("Could not generate token id", err)
+		return "", nil, err
+	}
+	now := time.Now()
+	claims := &Claims{
+		Cf:           user.Cf,
+		Role:         user.Role,
+		OrigIssuedAt: jwt.NewNumericDate(now),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   user.Cf,
+			ID:        hex.EncodeToString(id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.ttl)),
+		},
+	}
+	token := jwt.NewWithClaims(m.method, claims)
+	if m.keyID != "" {
+		token.Header["kid"] = m.keyID
+	}
+	signed, err := token.SignedString(m.signingKey)
+	if err != nil {
+		log.Println("Could not sign token", err)
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// Verify controlla firma, algoritmo, issuer, scadenza e durata massima dal login del token;
+// non controlla la denylist
+func (m *Manager) Verify(token string) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{m.method.Alg()}))
+	_, err := parser.ParseWithClaims(token, claims, func(parsed *jwt.Token) (interface{}, error) {
+		kid, _ := parsed.Header["kid"].(string)
+		key, ok := m.verificationKeys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err.Error())
+	}
+	if !claims.VerifyIssuer(issuer, true) || claims.ExpiresAt == nil || claims.ID == "" || claims.Cf == "" {
+		return nil, ErrInvalidToken
+	}
+	// anche un token con una scadenza più lunga, ad esempio firmato prima di ridurre la durata
+	// massima, non vale oltre maxAge dal login
+	if claims.OrigIssuedAt == nil || time.Since(claims.OrigIssuedAt.Time) > m.maxAge {
+		return nil, fmt.Errorf("%w: session older than %s", ErrInvalidToken, m.maxAge)
+	}
+	return claims, nil
+}
+
+// JSONWebKey è una chiave pubblica nel formato JWK (RFC 7517), pubblicata per i servizi che
+// verificano i token RS256
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS restituisce le chiavi pubbliche accettate in verifica, vuoto con HS256
+func (m *Manager) JWKS() []JSONWebKey {
+	keys := make([]JSONWebKey, 0, len(m.verificationKeys))
+	for kid, key := range m.verificationKeys {
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		keys = append(keys, JSONWebKey{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: m.method.Alg(),
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Kid < keys[j].Kid
+	})
+	return keys
+}
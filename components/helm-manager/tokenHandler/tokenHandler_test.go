@@ -0,0 +1,78 @@
+package tokenHandler
+
+import (
+	"errors"
+	"helm3-manager/config"
+	"helm3-manager/models"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestVerifyMaxAge(t *testing.T) {
+	manager, err := NewManager(config.SessionConfig{
+		Algorithm:     "HS256",
+		TTLSeconds:    3600,
+		MaxAgeSeconds: 7200,
+		HMACKey:       "0123456789abcdef0123456789abcdef",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// i token con orig_iat vengono firmati direttamente, come farebbe una versione precedente
+	sign := func(origIssuedAt *jwt.NumericDate) string {
+		signed, err := jwt.NewWithClaims(manager.method, &Claims{
+			Cf:           "student1",
+			Role:         models.RoleStudent,
+			OrigIssuedAt: origIssuedAt,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer,
+				ID:        "id",
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+		}).SignedString(manager.signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	issued, claims, err := manager.Issue(&models.User{Cf: "student1", Role: models.RoleStudent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.OrigIssuedAt == nil || !claims.OrigIssuedAt.Equal(claims.IssuedAt.Time) {
+		t.Errorf("orig_iat = %v, want %v", claims.OrigIssuedAt, claims.IssuedAt)
+	}
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "issued", token: issued},
+		{name: "within max age", token: sign(jwt.NewNumericDate(now.Add(-time.Hour)))},
+		{name: "older than max age", token: sign(jwt.NewNumericDate(now.Add(-3 * time.Hour))), wantErr: true},
+		{name: "without orig_iat", token: sign(nil), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := manager.Verify(test.token)
+			if test.wantErr != errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+}
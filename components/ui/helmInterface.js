@@ -190,6 +190,23 @@ async function getDeploymentLogs(chartJwt, podName, token) {
   }
 }
 
+// apre lo stream SSE dei log di un pod, le opzioni (container, tailLines, ...) sono passate in query string
+async function streamDeploymentLogs(chartJwt, podName, token, query) {
+  const response = await axios.get(
+    `${protocol}://${goServerIp}:${goServerPort}/logs/stream`,
+    {
+      headers: {
+        Authorization: token,
+        referredChart: chartJwt,
+        podName: podName,
+      },
+      params: query,
+      responseType: "stream",
+    }
+  );
+  return response.data;
+}
+
 async function setDeliveredChart(chartJwt, token) {
   try {
     const response = await axios.get(
@@ -234,6 +251,7 @@ module.exports = {
   stopChart,
   getDetails,
   getDeploymentLogs,
+  streamDeploymentLogs,
   setDeliveredChart,
   setUndeliveredChart,
 };
@@ -304,6 +304,35 @@ app.get("/dp-logs/:chartJwt/:podName", checkToken, async (req, res) => {
   }
 });
 
+app.get("/logs-stream/:chartJwt/:podName", checkToken, async (req, res) => {
+  const allowed = ["container", "tailLines", "sinceSeconds", "previous", "follow"];
+  const query = {};
+  for (const key of allowed) {
+    if (typeof req.query[key] !== "undefined") {
+      query[key] = req.query[key];
+    }
+  }
+  try {
+    const stream = await helmInterface.streamDeploymentLogs(
+      req.params.chartJwt,
+      req.params.podName,
+      req.session.token,
+      query
+    );
+    res.set({
+      "Content-Type": "text/event-stream",
+      "Cache-Control": "no-cache",
+      Connection: "keep-alive",
+    });
+    res.flushHeaders();
+    req.on("close", () => stream.destroy());
+    stream.pipe(res);
+  } catch (err) {
+    console.error("Error streaming logs:", err.message);
+    return res.status(404).send("Logs not found");
+  }
+});
+
 app.get(
   "/forward-to-port/:chartJwt/:service/:port/:namespace",
   checkToken,